@@ -1,11 +1,16 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"go-winx-api/internal/models"
 	"go-winx-api/internal/utils"
 
 	"github.com/joho/godotenv"
@@ -15,17 +20,60 @@ import (
 
 var ValueOf = &config{}
 
+var slugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 type config struct {
 	ApiId          int      `envconfig:"API_ID" required:"true"`
 	ApiHash        string   `envconfig:"API_HASH" required:"true"`
 	BotToken       string   `envconfig:"BOT_TOKEN" required:"true"`
-	ChannelId      int64    `envconfig:"CHANNEL_ID" required:"true"`
+	ChannelId      int64    `envconfig:"CHANNEL_ID"`
 	Port           int      `envconfig:"PORT" default:"8080"`
 	Host           string   `envconfig:"HOST" default:""`
 	HashLength     int      `envconfig:"HASH_LENGTH" default:"6"`
 	UserSession    string   `envconfig:"USER_SESSION"`
 	UsePublicIP    bool     `envconfig:"USE_PUBLIC_IP" default:"false"`
 	StringSessions []string `envconfig:"STRING_SESSIONS"`
+
+	ChannelSlug    string   `envconfig:"CHANNEL_SLUG" default:"main"`
+	ChannelProfile string   `envconfig:"CHANNEL_PROFILE" default:"winx"`
+	ChannelToken   string   `envconfig:"CHANNEL_TOKEN"`
+	ChannelSpecs   []string `envconfig:"CHANNELS"`
+	// Channels are CHANNEL_ID followed by the channels of CHANNELS.
+	Channels []models.Channel `ignored:"true"`
+
+	PostAssembly []string `envconfig:"POST_ASSEMBLY" default:"albums,standalone,sequences"`
+
+	WorkerStrategy    string `envconfig:"WORKER_STRATEGY" default:"least_busy"`
+	StreamConcurrency int    `envconfig:"STREAM_CONCURRENCY" default:"4"`
+	StreamWorkers     int    `envconfig:"STREAM_WORKERS" default:"1"`
+
+	IndexPath        string        `envconfig:"INDEX_PATH" default:"index.db"`
+	BackfillInterval time.Duration `envconfig:"BACKFILL_INTERVAL" default:"1h"`
+
+	CacheBackend string `envconfig:"CACHE_BACKEND" default:"memory"`
+	CacheSize    int    `envconfig:"CACHE_SIZE" default:"1024"` // in MB, for the memory backend
+	CachePath    string `envconfig:"CACHE_PATH" default:"cache.db"`
+	RedisURL     string `envconfig:"REDIS_URL"`
+
+	PostCacheSoftTTL time.Duration `envconfig:"POST_CACHE_SOFT_TTL" default:"1h"`
+	PostCacheHardTTL time.Duration `envconfig:"POST_CACHE_HARD_TTL" default:"12h"`
+	NegativeCacheTTL time.Duration `envconfig:"NEGATIVE_CACHE_TTL" default:"1m"`
+
+	ChunkCachePath string `envconfig:"CHUNK_CACHE_PATH"`
+	ChunkCacheSize int    `envconfig:"CHUNK_CACHE_SIZE" default:"10240"` // in MB
+
+	RequestTimeout     time.Duration `envconfig:"REQUEST_TIMEOUT" default:"30s"`
+	StreamChunkTimeout time.Duration `envconfig:"STREAM_CHUNK_TIMEOUT" default:"30s"`
+
+	HealthCheckInterval     time.Duration `envconfig:"HEALTH_CHECK_INTERVAL" default:"1m"`
+	CircuitBreakerThreshold int           `envconfig:"CIRCUIT_BREAKER_THRESHOLD" default:"3"`
+	CircuitBreakerCooldown  time.Duration `envconfig:"CIRCUIT_BREAKER_COOLDOWN" default:"2m"`
+	AdminToken              string        `envconfig:"ADMIN_TOKEN"`
+
+	SignedLinks        bool          `envconfig:"SIGNED_LINKS" default:"false"`
+	RequireSignedLinks bool          `envconfig:"REQUIRE_SIGNED_LINKS" default:"false"`
+	LinkSecret         string        `envconfig:"LINK_SECRET"`
+	LinkTTL            time.Duration `envconfig:"LINK_TTL" default:"0"`
 }
 
 func (c *config) loadFromEnvFile(log *zap.Logger) {
@@ -33,8 +81,6 @@ func (c *config) loadFromEnvFile(log *zap.Logger) {
 	log.Sugar().Infof("trying to load ENV vars from %s", envPath)
 	err := godotenv.Load(envPath)
 
-	c.StringSessions = strings.Split(os.Getenv("STRING_SESSIONS"), ",")
-
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Sugar().Errorf("ENV file not found: %s", envPath)
@@ -53,6 +99,7 @@ func (c *config) setupEnvVars(log *zap.Logger) {
 	if err != nil {
 		log.Fatal("error while parsing env variables", zap.Error(err))
 	}
+	c.StringSessions = trimSessions(c.StringSessions)
 	var ipBlocked bool
 	ip, err := utils.GetIP(c.UsePublicIP)
 	if err != nil {
@@ -73,11 +120,28 @@ func (c *config) setupEnvVars(log *zap.Logger) {
 	}
 }
 
+// trimSessions trims the sessions of STRING_SESSIONS and drops the blank
+// ones, such as those of an empty variable or a trailing comma.
+func trimSessions(sessions []string) []string {
+	trimmed := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		if session = strings.TrimSpace(session); session != "" {
+			trimmed = append(trimmed, session)
+		}
+	}
+	return trimmed
+}
+
 func Load(log *zap.Logger) {
 	log = log.Named("config")
 	defer log.Info("loaded config")
 	ValueOf.setupEnvVars(log)
 	ValueOf.ChannelId = int64(stripInt(log, int(ValueOf.ChannelId)))
+	channels, err := ValueOf.parseChannels(log)
+	if err != nil {
+		log.Fatal("error while parsing channels", zap.Error(err))
+	}
+	ValueOf.Channels = channels
 	if ValueOf.HashLength == 0 {
 		log.Sugar().Info("HASH_LENGTH can't be 0, defaulting to 6")
 		ValueOf.HashLength = 6
@@ -90,6 +154,131 @@ func Load(log *zap.Logger) {
 		log.Sugar().Info("HASH_LENGTH can't be less than 5, defaulting to 6")
 		ValueOf.HashLength = 6
 	}
+	switch ValueOf.CacheBackend {
+	case "memory", "disk":
+	case "redis":
+		if ValueOf.RedisURL == "" {
+			log.Sugar().Warn("CACHE_BACKEND is redis but REDIS_URL is not set, defaulting to memory")
+			ValueOf.CacheBackend = "memory"
+		}
+	default:
+		log.Sugar().Warnf("unknown CACHE_BACKEND %q, defaulting to memory", ValueOf.CacheBackend)
+		ValueOf.CacheBackend = "memory"
+	}
+	if ValueOf.CacheSize < 1 {
+		log.Sugar().Info("CACHE_SIZE can't be less than 1, defaulting to 1024")
+		ValueOf.CacheSize = 1024
+	}
+	if ValueOf.PostCacheHardTTL <= 0 {
+		log.Sugar().Info("POST_CACHE_HARD_TTL must be positive, defaulting to 12h")
+		ValueOf.PostCacheHardTTL = 12 * time.Hour
+	}
+	if ValueOf.PostCacheSoftTTL <= 0 || ValueOf.PostCacheSoftTTL > ValueOf.PostCacheHardTTL {
+		log.Sugar().Infof("POST_CACHE_SOFT_TTL must be between 0 and POST_CACHE_HARD_TTL, changing to %s", ValueOf.PostCacheHardTTL)
+		ValueOf.PostCacheSoftTTL = ValueOf.PostCacheHardTTL
+	}
+	if ValueOf.NegativeCacheTTL < 0 {
+		log.Sugar().Info("NEGATIVE_CACHE_TTL can't be negative, disabling it")
+		ValueOf.NegativeCacheTTL = 0
+	}
+	if ValueOf.ChunkCacheSize < 1 {
+		log.Sugar().Info("CHUNK_CACHE_SIZE can't be less than 1, defaulting to 10240")
+		ValueOf.ChunkCacheSize = 10240
+	}
+	if ValueOf.StreamConcurrency < 1 {
+		log.Sugar().Info("STREAM_CONCURRENCY can't be less than 1, defaulting to 1")
+		ValueOf.StreamConcurrency = 1
+	}
+	if ValueOf.StreamWorkers < 1 {
+		log.Sugar().Info("STREAM_WORKERS can't be less than 1, defaulting to 1")
+		ValueOf.StreamWorkers = 1
+	}
+	if ValueOf.RequestTimeout < 0 {
+		log.Sugar().Info("REQUEST_TIMEOUT can't be negative, disabling it")
+		ValueOf.RequestTimeout = 0
+	}
+	if ValueOf.StreamChunkTimeout < 0 {
+		log.Sugar().Info("STREAM_CHUNK_TIMEOUT can't be negative, disabling it")
+		ValueOf.StreamChunkTimeout = 0
+	}
+	if ValueOf.RequireSignedLinks && !ValueOf.SignedLinks {
+		log.Sugar().Info("REQUIRE_SIGNED_LINKS is set, enabling SIGNED_LINKS")
+		ValueOf.SignedLinks = true
+	}
+	if ValueOf.LinkTTL > 0 && ValueOf.LinkSecret == "" {
+		log.Sugar().Warn("LINK_TTL needs LINK_SECRET to sign links, disabling link expiry")
+		ValueOf.LinkTTL = 0
+	}
+	if ValueOf.CircuitBreakerThreshold < 1 {
+		log.Sugar().Info("CIRCUIT_BREAKER_THRESHOLD can't be less than 1, defaulting to 3")
+		ValueOf.CircuitBreakerThreshold = 3
+	}
+}
+
+// parseChannels reads the channel of CHANNEL_ID and those of CHANNELS, whose
+// entries are slug:id[:profile[:token]].
+func (c *config) parseChannels(log *zap.Logger) ([]models.Channel, error) {
+	var channels []models.Channel
+	add := func(slug string, id int64, profile, token string) error {
+		if !slugRegex.MatchString(slug) {
+			return fmt.Errorf("invalid channel slug %q", slug)
+		}
+		if profile == "" {
+			profile = utils.ProfileWinx
+		}
+		if !utils.ValidParserProfile(profile) {
+			log.Sugar().Warnf("unknown parser profile %q for channel %s, defaulting to %s", profile, slug, utils.ProfileWinx)
+			profile = utils.ProfileWinx
+		}
+		for _, channel := range channels {
+			if channel.Slug == slug || channel.ID == id {
+				return fmt.Errorf("channel %s is configured twice", slug)
+			}
+		}
+		channels = append(channels, models.Channel{
+			Slug:       slug,
+			ID:         id,
+			Profile:    profile,
+			Token:      token,
+			Restricted: token != "",
+		})
+		return nil
+	}
+
+	if c.ChannelId != 0 {
+		if err := add(c.ChannelSlug, c.ChannelId, c.ChannelProfile, c.ChannelToken); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range c.ChannelSpecs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 4)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid CHANNELS entry %q, expected slug:id[:profile[:token]]", spec)
+		}
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid channel id in CHANNELS entry %q", spec)
+		}
+		var profile, token string
+		if len(parts) > 2 {
+			profile = parts[2]
+		}
+		if len(parts) > 3 {
+			token = parts[3]
+		}
+		if err := add(parts[0], int64(stripInt(log, int(id))), profile, token); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(channels) == 0 {
+		return nil, errors.New("CHANNEL_ID or CHANNELS must be set")
+	}
+	return channels, nil
 }
 
 func stripInt(log *zap.Logger, a int) int {
@@ -3,100 +3,86 @@ package cache
 import (
 	"bytes"
 	"encoding/gob"
-	"github.com/coocood/freecache"
-	"github.com/gotd/td/tg"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-winx-api/config"
 	"go-winx-api/internal/models"
+
+	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
-	"sync"
 )
 
-var cache *Cache
+// Cache backends, selected with CACHE_BACKEND.
+const (
+	// BackendMemory keeps entries in the memory of the process.
+	BackendMemory = "memory"
+	// BackendDisk keeps entries in a SQLite database at CACHE_PATH, so they
+	// survive restarts.
+	BackendDisk = "disk"
+	// BackendRedis keeps entries in the Redis server at REDIS_URL, so that
+	// several replicas of the API share them.
+	BackendRedis = "redis"
+)
+
+// ErrNotFound is returned for a key that isn't cached or has expired.
+var ErrNotFound = errors.New("cache: key not found")
 
-type Cache struct {
-	cache *freecache.Cache
-	mu    sync.RWMutex
-	log   *zap.Logger
+// Cache stores values under string keys for up to a TTL. A TTL of zero
+// keeps the value until it's evicted or deleted.
+type Cache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Close() error
 }
 
-func InitCache(log *zap.Logger) {
+var cache Cache
+
+func InitCache(log *zap.Logger) error {
 	log = log.Named("cache")
 
 	gob.Register(models.File{})
 	gob.Register(tg.InputDocumentFileLocation{})
-	defer log.Sugar().Info("initialized")
+	gob.Register(models.Photo{})
+	gob.Register(tg.InputPhotoFileLocation{})
 
-	cache = &Cache{cache: freecache.NewCache(1024 * 1024 * 1024), log: log} // 1GB
-}
-
-func GetCache() *Cache {
-	return cache
-}
-
-func (c *Cache) GetFile(key string, value *models.File) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	data, err := cache.cache.Get([]byte(key))
-	if err != nil {
-		return err
+	var err error
+	switch config.ValueOf.CacheBackend {
+	case BackendDisk:
+		cache, err = newDiskCache(config.ValueOf.CachePath, log)
+	case BackendRedis:
+		cache, err = newRedisCache(config.ValueOf.RedisURL)
+	default:
+		cache = newMemoryCache(config.ValueOf.CacheSize * 1024 * 1024)
 	}
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err = dec.Decode(&value)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open %s cache: %w", config.ValueOf.CacheBackend, err)
 	}
+
+	log.Sugar().Infof("initialized with the %s backend", config.ValueOf.CacheBackend)
 	return nil
 }
 
-func (c *Cache) SetFile(key string, value *models.File, expireSeconds int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(value)
-	if err != nil {
-		return err
-	}
-	err = cache.cache.Set([]byte(key), buf.Bytes(), expireSeconds)
-	if err != nil {
-		return err
-	}
-	return nil
+func GetCache() Cache {
+	return cache
 }
 
-func (c *Cache) GetPost(key string, value *models.Post) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	data, err := cache.cache.Get([]byte(key))
-	if err != nil {
-		return err
-	}
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err = dec.Decode(&value)
+// Get decodes the value cached under key into value.
+func Get[T any](c Cache, key string, value *T) error {
+	data, err := c.Get(key)
 	if err != nil {
 		return err
 	}
-	return nil
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
 }
 
-func (c *Cache) SetPost(key string, value *models.Post, expireSeconds int) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// Set encodes value and caches it under key for ttl.
+func Set[T any](c Cache, key string, value *T, ttl time.Duration) error {
 	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(value)
-	if err != nil {
-		return err
-	}
-	err = cache.cache.Set([]byte(key), buf.Bytes(), expireSeconds)
-	if err != nil {
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
 		return err
 	}
-	return nil
-}
-
-func (c *Cache) Delete(key string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	cache.cache.Del([]byte(key))
-	return nil
+	return c.Set(key, buf.Bytes(), ttl)
 }
@@ -0,0 +1,353 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of files whose chunks are cached.
+const (
+	ChunkDocument = "documents"
+	ChunkPhoto    = "photos"
+)
+
+// Every chunk file starts with chunkMagic, the CRC-32C and the length of
+// the data, so that a truncated or corrupted chunk is never served.
+const (
+	chunkMagic      = "WXC1"
+	chunkHeaderSize = len(chunkMagic) + 4 + 8
+	chunkTempPrefix = ".tmp-"
+)
+
+var chunkTable = crc32.MakeTable(crc32.Castagnoli)
+
+var chunkCache *ChunkCache
+
+// ChunkKey names a chunk of a file by the Telegram ID of the document or
+// photo and the offset the chunk starts at.
+type ChunkKey struct {
+	Kind   string
+	FileID int64
+	Offset int64
+}
+
+// name is the path of the chunk relative to the cache directory. Files are
+// spread over 256 directories so none of them grows too large.
+func (k ChunkKey) name() string {
+	return filepath.Join(k.Kind, fmt.Sprintf("%02x", uint64(k.FileID)%256), fmt.Sprintf("%d-%d", k.FileID, k.Offset))
+}
+
+// ChunkCache keeps chunks of media on disk, evicting the least recently
+// used ones once they take more than its max size. The recency of the
+// chunks is kept in memory, and rebuilt from their modification times on
+// start.
+type ChunkCache struct {
+	dir     string
+	maxSize int64
+	log     *zap.Logger
+
+	mu      sync.Mutex
+	lru     *list.List // of *chunkEntry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	writes    atomic.Uint64
+	evictions atomic.Uint64
+	corrupted atomic.Uint64
+}
+
+type chunkEntry struct {
+	name string
+	size int64
+}
+
+func InitChunkCache(log *zap.Logger) error {
+	log = log.Named("chunk_cache")
+
+	if config.ValueOf.ChunkCachePath == "" {
+		log.Sugar().Info("CHUNK_CACHE_PATH not set, media chunk cache disabled")
+		return nil
+	}
+
+	c, err := newChunkCache(config.ValueOf.ChunkCachePath, int64(config.ValueOf.ChunkCacheSize)*1024*1024, log)
+	if err != nil {
+		return err
+	}
+	chunkCache = c
+
+	log.Sugar().Infof("initialized at %s with %d chunks (%d of %d MB)",
+		c.dir, len(c.entries), c.size/1024/1024, config.ValueOf.ChunkCacheSize)
+	return nil
+}
+
+func newChunkCache(dir string, maxSize int64, log *zap.Logger) (*ChunkCache, error) {
+	c := &ChunkCache{
+		dir:     dir,
+		maxSize: maxSize,
+		log:     log,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create chunk cache directory: %w", err)
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("failed to load chunk cache: %w", err)
+	}
+	return c, nil
+}
+
+// GetChunkCache returns the media chunk cache, or nil when it's disabled.
+func GetChunkCache() *ChunkCache {
+	return chunkCache
+}
+
+// Get returns the cached chunk of key, if any.
+func (c *ChunkCache) Get(key ChunkKey) ([]byte, bool) {
+	if c == nil || key.FileID == 0 {
+		return nil, false
+	}
+
+	name := key.name()
+	c.mu.Lock()
+	element, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		c.log.Warn("failed to read chunk", zap.String("chunk", name), zap.Error(err))
+		c.drop(name, element)
+		c.misses.Add(1)
+		return nil, false
+	}
+	data, err := decodeChunk(file)
+	if err != nil {
+		c.log.Warn("dropping corrupted chunk", zap.String("chunk", name), zap.Error(err))
+		c.drop(name, element)
+		c.corrupted.Add(1)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	// the modification time keeps the recency of the chunk across restarts
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	c.hits.Add(1)
+	return data, true
+}
+
+// Put caches data as the chunk of key, evicting the least recently used
+// chunks to make room for it.
+func (c *ChunkCache) Put(key ChunkKey, data []byte) error {
+	if c == nil || key.FileID == 0 || len(data) == 0 {
+		return nil
+	}
+	size := int64(chunkHeaderSize + len(data))
+	if size > c.maxSize {
+		return nil
+	}
+
+	name := key.name()
+	path := filepath.Join(c.dir, name)
+	temp, err := writeTempChunk(path, data)
+	if err != nil {
+		return err
+	}
+
+	// the chunk is only renamed into place under c.mu, so that one dropped
+	// by Get at the same time never takes it along
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	c.writes.Add(1)
+
+	if element, ok := c.entries[name]; ok {
+		c.size -= element.Value.(*chunkEntry).size
+		c.lru.Remove(element)
+	}
+	c.entries[name] = c.lru.PushFront(&chunkEntry{name: name, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// Stats returns the size of the cache and how well it's been serving.
+func (c *ChunkCache) Stats() models.ChunkCacheStats {
+	if c == nil {
+		return models.ChunkCacheStats{}
+	}
+
+	c.mu.Lock()
+	entries, size := len(c.entries), c.size
+	c.mu.Unlock()
+
+	stats := models.ChunkCacheStats{
+		Enabled:   true,
+		Entries:   entries,
+		Size:      size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Writes:    c.writes.Load(),
+		Evictions: c.evictions.Load(),
+		Corrupted: c.corrupted.Load(),
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// evict drops the least recently used chunks until the cache fits its max
+// size. c.mu must be held.
+func (c *ChunkCache) evict() {
+	for c.size > c.maxSize {
+		element := c.lru.Back()
+		if element == nil {
+			return
+		}
+		entry := element.Value.(*chunkEntry)
+		c.lru.Remove(element)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+		c.evictions.Add(1)
+
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.log.Warn("failed to evict chunk", zap.String("chunk", entry.name), zap.Error(err))
+		}
+	}
+}
+
+// drop removes the chunk name that failed to be read, unless it was put
+// again or evicted since element was looked up for it.
+func (c *ChunkCache) drop(name string, element *list.Element) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[name] != element {
+		return
+	}
+	c.size -= element.Value.(*chunkEntry).size
+	c.lru.Remove(element)
+	delete(c.entries, name)
+	_ = os.Remove(filepath.Join(c.dir, name))
+}
+
+// load indexes the chunks already on disk, the most recently used first,
+// and removes the temporary files of writes that never finished.
+func (c *ChunkCache) load() error {
+	type found struct {
+		entry   *chunkEntry
+		modTime time.Time
+	}
+	var chunks []found
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), chunkTempPrefix) {
+			return os.Remove(path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, found{entry: &chunkEntry{name: name, size: info.Size()}, modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].modTime.Before(chunks[j].modTime)
+	})
+	for _, chunk := range chunks {
+		c.entries[chunk.entry.name] = c.lru.PushFront(chunk.entry)
+		c.size += chunk.entry.size
+	}
+	c.evict()
+	return nil
+}
+
+// writeTempChunk writes data to a temporary file next to path and returns
+// its name, to be renamed into place so a chunk is never read half written.
+func writeTempChunk(path string, data []byte) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, chunkTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, chunkHeaderSize)
+	copy(header, chunkMagic)
+	binary.BigEndian.PutUint32(header[len(chunkMagic):], crc32.Checksum(data, chunkTable))
+	binary.BigEndian.PutUint64(header[len(chunkMagic)+4:], uint64(len(data)))
+
+	_, err = file.Write(header)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// decodeChunk checks the header of a chunk file and returns its data.
+func decodeChunk(file []byte) ([]byte, error) {
+	if len(file) < chunkHeaderSize || string(file[:len(chunkMagic)]) != chunkMagic {
+		return nil, errors.New("invalid chunk header")
+	}
+	checksum := binary.BigEndian.Uint32(file[len(chunkMagic):])
+	length := binary.BigEndian.Uint64(file[len(chunkMagic)+4:])
+
+	data := file[chunkHeaderSize:]
+	if uint64(len(data)) != length {
+		return nil, fmt.Errorf("chunk is %d bytes, expected %d", len(data), length)
+	}
+	if crc32.Checksum(data, chunkTable) != checksum {
+		return nil, errors.New("chunk checksum mismatch")
+	}
+	return data, nil
+}
@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testChunk is the data of the chunk of a test file at offset, 100 bytes
+// taking 100+chunkHeaderSize on disk.
+func testChunk(offset int64) []byte {
+	return bytes.Repeat([]byte{byte(offset)}, 100)
+}
+
+const testChunkSize = int64(100 + chunkHeaderSize)
+
+func testKey(offset int64) ChunkKey {
+	return ChunkKey{Kind: ChunkDocument, FileID: 42, Offset: offset}
+}
+
+func newTestChunkCache(t *testing.T, dir string, chunks int64) *ChunkCache {
+	t.Helper()
+	c, err := newChunkCache(dir, chunks*testChunkSize, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newChunkCache() error = %v", err)
+	}
+	return c
+}
+
+// cached returns the offsets of the chunks of testKey in c, most recently
+// used first.
+func cached(c *ChunkCache) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var offsets []int64
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		for offset := int64(0); offset < 10; offset++ {
+			if element.Value.(*chunkEntry).name == testKey(offset).name() {
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+	return offsets
+}
+
+func TestChunkCacheLRU(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int64
+		puts    []int64
+		gets    []int64
+		want    []int64
+		evicted uint64
+	}{
+		{name: "most recently put first", max: 3, puts: []int64{1, 2, 3}, want: []int64{3, 2, 1}},
+		{name: "get moves to front", max: 3, puts: []int64{1, 2, 3}, gets: []int64{1}, want: []int64{1, 3, 2}},
+		{name: "put again moves to front", max: 3, puts: []int64{1, 2, 3, 1}, want: []int64{1, 3, 2}},
+		{name: "evicts least recently put", max: 3, puts: []int64{1, 2, 3, 4}, want: []int64{4, 3, 2}, evicted: 1},
+		{name: "evicts least recently got", max: 3, puts: []int64{1, 2, 3}, gets: []int64{1, 2}, want: []int64{2, 1, 3}},
+		{name: "evicts down to max size", max: 2, puts: []int64{1, 2, 3, 4, 5}, want: []int64{5, 4}, evicted: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChunkCache(t, t.TempDir(), tt.max)
+			for _, offset := range tt.puts {
+				if err := c.Put(testKey(offset), testChunk(offset)); err != nil {
+					t.Fatalf("Put(%d) error = %v", offset, err)
+				}
+			}
+			for _, offset := range tt.gets {
+				if _, ok := c.Get(testKey(offset)); !ok {
+					t.Fatalf("Get(%d) missed", offset)
+				}
+			}
+			if tt.evicted > 0 {
+				// only the puts past max evict, whatever was got in between
+				if _, ok := c.Get(testKey(tt.puts[0])); ok {
+					t.Errorf("Get(%d) hit an evicted chunk", tt.puts[0])
+				}
+			}
+
+			if got := cached(c); !slices.Equal(got, tt.want) {
+				t.Errorf("cached chunks = %v, want %v", got, tt.want)
+			}
+			stats := c.Stats()
+			if stats.Evictions != tt.evicted {
+				t.Errorf("evictions = %d, want %d", stats.Evictions, tt.evicted)
+			}
+			if stats.Size != int64(len(tt.want))*testChunkSize || stats.Size > stats.MaxSize {
+				t.Errorf("size = %d of %d, want %d", stats.Size, stats.MaxSize, int64(len(tt.want))*testChunkSize)
+			}
+		})
+	}
+}
+
+func TestChunkCacheEvictionRemovesFiles(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestChunkCache(t, dir, 2)
+	for offset := int64(1); offset <= 3; offset++ {
+		if err := c.Put(testKey(offset), testChunk(offset)); err != nil {
+			t.Fatalf("Put(%d) error = %v", offset, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, testKey(1).name())); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("evicted chunk is still on disk: %v", err)
+	}
+	for offset := int64(2); offset <= 3; offset++ {
+		data, ok := c.Get(testKey(offset))
+		if !ok || !bytes.Equal(data, testChunk(offset)) {
+			t.Errorf("Get(%d) = %v, %v", offset, len(data), ok)
+		}
+	}
+}
+
+func TestChunkCacheSkips(t *testing.T) {
+	c := newTestChunkCache(t, t.TempDir(), 1)
+
+	if err := c.Put(testKey(1), bytes.Repeat([]byte{1}, 101)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := c.Put(ChunkKey{Kind: ChunkDocument, Offset: 1}, testChunk(1)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := c.Put(testKey(2), nil); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Writes != 0 {
+		t.Errorf("cached %d chunks in %d writes, want none", stats.Entries, stats.Writes)
+	}
+
+	var disabled *ChunkCache
+	if _, ok := disabled.Get(testKey(1)); ok {
+		t.Error("Get() hit a disabled cache")
+	}
+	if err := disabled.Put(testKey(1), testChunk(1)); err != nil {
+		t.Errorf("Put() on a disabled cache error = %v", err)
+	}
+}
+
+func TestDecodeChunk(t *testing.T) {
+	data := []byte("chunk data")
+	valid := encodeTestChunk(t, data)
+
+	tests := []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{name: "valid", file: valid},
+		{name: "empty data", file: encodeTestChunk(t, nil)},
+		{name: "empty file", file: nil, err: "invalid chunk header"},
+		{name: "short header", file: valid[:chunkHeaderSize-1], err: "invalid chunk header"},
+		{name: "bad magic", file: append([]byte("XXXX"), valid[len(chunkMagic):]...), err: "invalid chunk header"},
+		{name: "truncated", file: valid[:len(valid)-1], err: "expected 10"},
+		{name: "trailing bytes", file: append(bytes.Clone(valid), 0), err: "expected 10"},
+		{name: "flipped byte", file: flipLastByte(valid), err: "checksum mismatch"},
+		{name: "bad checksum", file: withChecksum(valid, 0), err: "checksum mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeChunk(tt.file)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("decodeChunk() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeChunk() error = %v", err)
+			}
+			if want := tt.file[chunkHeaderSize:]; !bytes.Equal(got, want) {
+				t.Errorf("decodeChunk() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestChunkCacheDropsCorrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(file []byte) []byte
+	}{
+		{name: "truncated", corrupt: func(file []byte) []byte { return file[:len(file)-10] }},
+		{name: "flipped byte", corrupt: flipLastByte},
+		{name: "no header", corrupt: func(file []byte) []byte { return file[chunkHeaderSize:] }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := newTestChunkCache(t, dir, 2)
+			if err := c.Put(testKey(1), testChunk(1)); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			path := filepath.Join(dir, testKey(1).name())
+			file, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read chunk: %v", err)
+			}
+			if err := os.WriteFile(path, tt.corrupt(file), 0o644); err != nil {
+				t.Fatalf("failed to corrupt chunk: %v", err)
+			}
+
+			if _, ok := c.Get(testKey(1)); ok {
+				t.Fatal("Get() served a corrupted chunk")
+			}
+			stats := c.Stats()
+			if stats.Corrupted != 1 || stats.Misses != 1 || stats.Entries != 0 || stats.Size != 0 {
+				t.Errorf("stats = %+v, want one corrupted miss and no entries", stats)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("corrupted chunk is still on disk: %v", err)
+			}
+		})
+	}
+}
+
+func TestChunkCacheDropKeepsNewerPut(t *testing.T) {
+	c := newTestChunkCache(t, t.TempDir(), 2)
+	if err := c.Put(testKey(1), testChunk(1)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// a Get that failed to read the chunk drops it after a Put replaced it
+	name := testKey(1).name()
+	c.mu.Lock()
+	stale := c.entries[name]
+	c.mu.Unlock()
+	if err := c.Put(testKey(1), testChunk(2)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	c.drop(name, stale)
+
+	data, ok := c.Get(testKey(1))
+	if !ok || !bytes.Equal(data, testChunk(2)) {
+		t.Errorf("Get() after a stale drop = %v, %v, want the newer chunk", len(data), ok)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Size != testChunkSize {
+		t.Errorf("stats = %+v, want one entry", stats)
+	}
+}
+
+func TestChunkCacheLoad(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestChunkCache(t, dir, 4)
+	for offset := int64(1); offset <= 4; offset++ {
+		if err := c.Put(testKey(offset), testChunk(offset)); err != nil {
+			t.Fatalf("Put(%d) error = %v", offset, err)
+		}
+	}
+
+	// recency comes back from the modification times, 3 being the most
+	// recently used and 2 the least
+	base := time.Now().Add(-time.Hour)
+	for i, offset := range []int64{2, 4, 1, 3} {
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, testKey(offset).name()), modTime, modTime); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+
+	temp := filepath.Join(dir, filepath.Dir(testKey(1).name()), chunkTempPrefix+"unfinished")
+	if err := os.WriteFile(temp, []byte("half written"), 0o644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	loaded := newTestChunkCache(t, dir, 4)
+	if got, want := cached(loaded), []int64{3, 1, 4, 2}; !slices.Equal(got, want) {
+		t.Errorf("loaded chunks = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(temp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("temporary file was not removed: %v", err)
+	}
+	if stats := loaded.Stats(); stats.Entries != 4 || stats.Size != 4*testChunkSize {
+		t.Errorf("stats = %+v, want four entries", stats)
+	}
+
+	// a smaller cache evicts the least recently used chunks on load
+	shrunk := newTestChunkCache(t, dir, 2)
+	if got, want := cached(shrunk), []int64{3, 1}; !slices.Equal(got, want) {
+		t.Errorf("loaded chunks = %v, want %v", got, want)
+	}
+	for _, offset := range []int64{2, 4} {
+		if _, err := os.Stat(filepath.Join(dir, testKey(offset).name())); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("chunk %d evicted on load is still on disk: %v", offset, err)
+		}
+	}
+}
+
+func encodeTestChunk(t *testing.T, data []byte) []byte {
+	t.Helper()
+	temp, err := writeTempChunk(filepath.Join(t.TempDir(), "chunk"), data)
+	if err != nil {
+		t.Fatalf("writeTempChunk() error = %v", err)
+	}
+	file, err := os.ReadFile(temp)
+	if err != nil {
+		t.Fatalf("failed to read chunk: %v", err)
+	}
+	return file
+}
+
+func flipLastByte(file []byte) []byte {
+	file = bytes.Clone(file)
+	file[len(file)-1] ^= 0xff
+	return file
+}
+
+func withChecksum(file []byte, checksum uint32) []byte {
+	file = bytes.Clone(file)
+	binary.BigEndian.PutUint32(file[len(chunkMagic):], checksum)
+	return file
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"go.uber.org/zap"
+)
+
+const diskSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	key        TEXT PRIMARY KEY,
+	value      BLOB NOT NULL,
+	expires_at INTEGER NOT NULL
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS entries_expires_at ON entries(expires_at) WHERE expires_at > 0;
+`
+
+// purgeInterval is how often expired entries are deleted from the disk.
+const purgeInterval = 10 * time.Minute
+
+// diskCache keeps entries in a SQLite database, expiring them by the
+// unix time in milliseconds in expires_at, zero for never.
+type diskCache struct {
+	db   *sql.DB
+	log  *zap.Logger
+	stop chan struct{}
+}
+
+func newDiskCache(path string, log *zap.Logger) (*diskCache, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so writes are serialized here instead
+	// of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(diskSchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	c := &diskCache{db: db, log: log, stop: make(chan struct{})}
+	go c.purgeLoop()
+	return c, nil
+}
+
+func (c *diskCache) Get(key string) ([]byte, error) {
+	var data []byte
+	err := c.db.QueryRow(
+		`SELECT value FROM entries WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`,
+		key, time.Now().UnixMilli(),
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (c *diskCache) Set(key string, value []byte, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixMilli()
+	}
+	_, err := c.db.Exec(
+		`INSERT INTO entries (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, value, expiresAt,
+	)
+	return err
+}
+
+func (c *diskCache) Delete(key string) error {
+	_, err := c.db.Exec(`DELETE FROM entries WHERE key = ?`, key)
+	return err
+}
+
+func (c *diskCache) Close() error {
+	close(c.stop)
+	return c.db.Close()
+}
+
+func (c *diskCache) purgeLoop() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			result, err := c.db.ExecContext(ctx,
+				`DELETE FROM entries WHERE expires_at > 0 AND expires_at <= ?`, time.Now().UnixMilli())
+			cancel()
+			if err != nil {
+				c.log.Error("failed to purge expired entries", zap.Error(err))
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				c.log.Sugar().Debugf("purged %d expired entries", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+// memoryCache keeps entries in a freecache of a fixed size, evicting the
+// least recently used ones when it's full.
+type memoryCache struct {
+	cache *freecache.Cache
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{cache: freecache.NewCache(size)}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) {
+	data, err := c.cache.Get([]byte(key))
+	if errors.Is(err, freecache.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	// freecache counts in seconds, so a shorter TTL is rounded up rather
+	// than becoming no expiry at all
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	return c.cache.Set([]byte(key), value, seconds)
+}
+
+func (c *memoryCache) Delete(key string) error {
+	c.cache.Del([]byte(key))
+	return nil
+}
+
+func (c *memoryCache) Close() error {
+	return nil
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedisReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+		err   string
+	}{
+		{name: "simple string", input: "+OK\r\n", want: "OK"},
+		{name: "bulk string", input: "$5\r\nhello\r\n", want: []byte("hello")},
+		{name: "empty bulk string", input: "$0\r\n\r\n", want: []byte{}},
+		{name: "bulk string with crlf", input: "$4\r\na\r\nb\r\n", want: []byte("a\r\nb")},
+		{name: "nil bulk string", input: "$-1\r\n", want: nil},
+		{name: "integer", input: ":42\r\n", want: int64(42)},
+		{name: "negative integer", input: ":-1\r\n", want: int64(-1)},
+		{name: "array", input: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", want: []any{[]byte("a"), int64(1), nil}},
+		{name: "nil array", input: "*-1\r\n", want: nil},
+		{name: "error", input: "-ERR unknown command\r\n", err: "redis: ERR unknown command"},
+		{name: "invalid bulk length", input: "$x\r\n", err: "invalid bulk length"},
+		{name: "short bulk string", input: "$5\r\nhi\r\n", err: "unexpected EOF"},
+		{name: "unknown type", input: "?\r\n", err: "unexpected reply"},
+		{name: "empty reply", input: "\r\n", err: "empty reply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &redisConn{r: bufio.NewReader(strings.NewReader(tt.input))}
+			got, err := conn.readReply()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("readReply() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readReply() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readReply() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisErrorReply(t *testing.T) {
+	conn := &redisConn{r: bufio.NewReader(strings.NewReader("-WRONGTYPE not a string\r\n"))}
+	_, err := conn.readReply()
+
+	var replyErr redisError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("readReply() error = %v, want a redisError", err)
+	}
+	if string(replyErr) != "WRONGTYPE not a string" {
+		t.Errorf("redisError = %q", string(replyErr))
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	server := newFakeRedis(t, "", "")
+	c, err := newRedisCache("redis://" + server.addr)
+	if err != nil {
+		t.Fatalf("newRedisCache() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := c.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	got, err := c.Get("key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get(key) = %q, %v, want %q", got, err, "value")
+	}
+	if ttl := server.ttl("key"); ttl != "" {
+		t.Errorf("Set() without ttl sent PX %s", ttl)
+	}
+
+	if err := c.Set("expiring", []byte("value"), 1500*time.Microsecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if ttl := server.ttl("expiring"); ttl != "1" {
+		t.Errorf("Set() with ttl sent PX %q, want %q", ttl, "1")
+	}
+
+	if err := c.Delete("key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := c.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(key) after Delete() error = %v, want ErrNotFound", err)
+	}
+
+	// an error reply leaves the connection usable
+	var replyErr redisError
+	if _, err := c.do("FAIL"); !errors.As(err, &replyErr) {
+		t.Fatalf("do(FAIL) error = %v, want a redisError", err)
+	}
+	if err := c.Set("key", []byte("again"), 0); err != nil {
+		t.Fatalf("Set() after an error reply error = %v", err)
+	}
+	if dials := server.dials(); dials != 1 {
+		t.Errorf("server saw %d connections, want 1", dials)
+	}
+}
+
+func TestRedisCacheAuth(t *testing.T) {
+	server := newFakeRedis(t, "user", "secret")
+
+	c, err := newRedisCache("redis://user:secret@" + server.addr + "/2")
+	if err != nil {
+		t.Fatalf("newRedisCache() error = %v", err)
+	}
+	defer c.Close()
+	if db := server.selected(); db != "2" {
+		t.Errorf("selected database %q, want %q", db, "2")
+	}
+
+	if _, err := newRedisCache("redis://user:wrong@" + server.addr); err == nil {
+		t.Error("newRedisCache() with a wrong password succeeded")
+	}
+}
+
+func TestRedisCacheInvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"http://localhost", "redis://localhost/db"} {
+		if _, err := newRedisCache(rawURL); err == nil {
+			t.Errorf("newRedisCache(%q) succeeded", rawURL)
+		}
+	}
+}
+
+// fakeRedis is a stand-in Redis server that understands the few commands
+// redisCache sends.
+type fakeRedis struct {
+	addr     string
+	username string
+	password string
+
+	mu      sync.Mutex
+	data    map[string][]byte
+	ttls    map[string]string
+	db      string
+	dialled int
+}
+
+func newFakeRedis(t *testing.T, username, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeRedis{
+		addr:     listener.Addr().String(),
+		username: username,
+		password: password,
+		data:     make(map[string][]byte),
+		ttls:     make(map[string]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.dialled++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(netConn net.Conn) {
+	defer netConn.Close()
+	conn := &redisConn{conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+	authed := s.password == ""
+
+	for {
+		reply, err := conn.readReply()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		var args []string
+		for _, item := range items {
+			data, _ := item.([]byte)
+			args = append(args, string(data))
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		var out string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "AUTH":
+			authed = len(args) == 3 && args[1] == s.username && args[2] == s.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		default:
+			out = s.run(command, args[1:])
+		}
+		if _, err := conn.w.WriteString(out); err != nil || conn.w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRedis) run(command string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch command {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		s.db = args[0]
+		return "+OK\r\n"
+	case "GET":
+		value, ok := s.data[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		s.data[args[0]] = bytes.Clone([]byte(args[1]))
+		s.ttls[args[0]] = ""
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			s.ttls[args[0]] = args[3]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := s.data[args[0]]
+		delete(s.data, args[0])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", command)
+	}
+}
+
+func (s *fakeRedis) ttl(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttls[key]
+}
+
+func (s *fakeRedis) selected() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db
+}
+
+func (s *fakeRedis) dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dialled
+}
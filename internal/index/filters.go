@@ -0,0 +1,154 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+// FieldYear is the facet of the release year, which isn't stored as a term.
+const FieldYear = "year"
+
+// PeopleFields are the fields that hold the names of people.
+var PeopleFields = []string{FieldDirector, FieldWriter, FieldCast}
+
+// facetLimit is the number of values returned for each facet.
+const facetLimit = 50
+
+// conditions returns the joins, WHERE clauses and arguments selecting the
+// posts that match query, leaving out the filter on exclude. ok is false
+// when the query can't match anything.
+func (q Query) conditions(exclude string) (joins string, where []string, args []any, ok bool) {
+	if len(q.Channels) > 0 {
+		where = append(where, "posts.channel_id IN ("+placeholders(len(q.Channels))+")")
+		for _, id := range q.Channels {
+			args = append(args, id)
+		}
+	}
+
+	if q.Search != "" {
+		match := matchExpression(q.Search)
+		if match == "" {
+			return "", nil, nil, false
+		}
+		joins = " JOIN posts_search ON posts_search.rowid = posts.id"
+		where = append(where, "posts_search MATCH ?")
+		args = append(args, match)
+	}
+
+	filters := q.Filters
+	for _, f := range []struct {
+		fields []string
+		values []string
+	}{
+		{[]string{FieldGenre}, filters.Genres},
+		{[]string{FieldCountry}, filters.Countries},
+		{[]string{FieldLanguage}, filters.Languages},
+		{[]string{FieldSubtitle}, filters.Subtitles},
+		{[]string{FieldTag}, filters.Tags},
+		{[]string{FieldDirector}, filters.Directors},
+		{[]string{FieldWriter}, filters.Writers},
+		{[]string{FieldCast}, filters.Cast},
+		{PeopleFields, filters.People},
+	} {
+		if len(f.values) == 0 || slices.Contains(f.fields, exclude) {
+			continue
+		}
+		where = append(where, "posts.id IN (SELECT post_id FROM post_terms WHERE field IN ("+
+			placeholders(len(f.fields))+") AND normalized IN ("+placeholders(len(f.values))+"))")
+		for _, field := range f.fields {
+			args = append(args, field)
+		}
+		for _, value := range f.values {
+			args = append(args, Normalize(value))
+		}
+	}
+
+	if exclude != FieldYear {
+		if filters.YearFrom > 0 {
+			where = append(where, "posts.release_year != '' AND CAST(posts.release_year AS INTEGER) >= ?")
+			args = append(args, filters.YearFrom)
+		}
+		if filters.YearTo > 0 {
+			where = append(where, "posts.release_year != '' AND CAST(posts.release_year AS INTEGER) <= ?")
+			args = append(args, filters.YearTo)
+		}
+	}
+
+	if filters.HasSubtitles != nil {
+		clause := "EXISTS (SELECT 1 FROM post_terms WHERE post_terms.post_id = posts.id AND field = ?)"
+		if !*filters.HasSubtitles {
+			clause = "NOT " + clause
+		}
+		where = append(where, clause)
+		args = append(args, FieldSubtitle)
+	}
+
+	return joins, where, args, true
+}
+
+// Facets counts the posts matching query for each value of the filterable
+// fields. The filter on a field is ignored when counting its own values, so
+// the counts show what choosing another value would return.
+func (i *Index) Facets(ctx context.Context, query Query) (map[string][]models.Facet, error) {
+	facets := make(map[string][]models.Facet)
+
+	for _, field := range []string{FieldGenre, FieldCountry, FieldLanguage, FieldSubtitle, FieldTag} {
+		joins, where, args, ok := query.conditions(field)
+		if !ok {
+			return facets, nil
+		}
+		where = append([]string{"post_terms.field = ?"}, where...)
+		args = append([]any{field}, args...)
+
+		values, err := i.queryFacets(ctx, `
+			SELECT MIN(post_terms.value), COUNT(DISTINCT posts.id) FROM post_terms
+			JOIN posts ON posts.id = post_terms.post_id`+joins+`
+			WHERE `+strings.Join(where, " AND ")+`
+			GROUP BY post_terms.normalized
+			ORDER BY COUNT(DISTINCT posts.id) DESC, MIN(post_terms.value)
+			LIMIT ?`, append(args, facetLimit)...)
+		if err != nil {
+			return nil, err
+		}
+		facets[field] = values
+	}
+
+	joins, where, args, _ := query.conditions(FieldYear)
+	where = append([]string{"posts.release_year != ''"}, where...)
+	years, err := i.queryFacets(ctx, `
+		SELECT posts.release_year, COUNT(*) FROM posts`+joins+`
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY posts.release_year
+		ORDER BY posts.release_year DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	facets[FieldYear] = years
+
+	return facets, nil
+}
+
+func (i *Index) queryFacets(ctx context.Context, stmt string, args ...any) ([]models.Facet, error) {
+	rows, err := i.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := make([]models.Facet, 0)
+	for rows.Next() {
+		var facet models.Facet
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			return nil, err
+		}
+		facets = append(facets, facet)
+	}
+	return facets, rows.Err()
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
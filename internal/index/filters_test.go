@@ -0,0 +1,112 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go-winx-api/internal/models"
+)
+
+func TestFilters(t *testing.T) {
+	idx := newTestPosts(t)
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		channels []int64
+		filters  models.PostFilters
+		want     []string
+	}{
+		{name: "none", want: []string{"1/40", "2/20", "2/10", "1/30", "1/20", "2/30", "1/10"}},
+		{name: "channel", channels: []int64{2}, want: []string{"2/20", "2/10", "2/30"}},
+		{name: "genre", filters: models.PostFilters{Genres: []string{"Drama"}}, want: []string{"1/30", "1/20", "2/30", "1/10"}},
+		{name: "genre without accents", filters: models.PostFilters{Genres: []string{"acao"}}, want: []string{"2/10", "1/30"}},
+		{name: "any of several genres", filters: models.PostFilters{Genres: []string{"crime", "COMÉDIA"}}, want: []string{"1/40", "2/20", "2/10", "1/10"}},
+		{name: "country", filters: models.PostFilters{Countries: []string{"eua"}}, want: []string{"1/30"}},
+		{name: "language", filters: models.PostFilters{Languages: []string{"Inglês"}}, want: []string{"1/30"}},
+		{name: "subtitle", filters: models.PostFilters{Subtitles: []string{"espanhol"}}, want: []string{"2/20"}},
+		{name: "tag", filters: models.PostFilters{Tags: []string{"epico"}}, want: []string{"1/30"}},
+		{name: "director", filters: models.PostFilters{Directors: []string{"Fernando  Meirelles"}}, want: []string{"2/30", "1/10"}},
+		{name: "writer", filters: models.PostFilters{Writers: []string{"ariano suassuna"}}, want: []string{"2/20"}},
+		{name: "cast", filters: models.PostFilters{Cast: []string{"Mel Gibson"}}, want: []string{"1/30"}},
+		{name: "director not in cast", filters: models.PostFilters{Cast: []string{"Walter Salles"}}},
+		{name: "person in several roles", filters: models.PostFilters{People: []string{"mel gibson"}}, want: []string{"1/30"}},
+		{name: "people", filters: models.PostFilters{People: []string{"Fernando Meirelles", "Selton Mello"}}, want: []string{"2/20", "2/30", "1/10"}},
+		{name: "year from", filters: models.PostFilters{YearFrom: 2000}, want: []string{"2/20", "2/30", "1/10"}},
+		{name: "year to", filters: models.PostFilters{YearTo: 1995}, want: []string{"2/10", "1/30"}},
+		{name: "years", filters: models.PostFilters{YearFrom: 1995, YearTo: 1999}, want: []string{"1/30", "1/20"}},
+		{name: "with subtitles", filters: models.PostFilters{HasSubtitles: &yes}, want: []string{"1/40", "2/20", "1/30", "1/10"}},
+		{name: "without subtitles", filters: models.PostFilters{HasSubtitles: &no}, want: []string{"2/10", "1/20", "2/30"}},
+		{name: "all of several fields", filters: models.PostFilters{Genres: []string{"drama"}, Countries: []string{"brasil"}}, want: []string{"1/20", "2/30", "1/10"}},
+		{name: "filter and channel", channels: []int64{2}, filters: models.PostFilters{Genres: []string{"comedia"}}, want: []string{"2/20", "2/10"}},
+		{name: "no match", filters: models.PostFilters{Genres: []string{"Terror"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed(t, idx, Query{Channels: tt.channels, Filters: tt.filters}, tt.want...)
+		})
+	}
+}
+
+func TestFacets(t *testing.T) {
+	idx := newTestPosts(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  map[string][]models.Facet
+	}{
+		{
+			// the genres are counted as if no genre was chosen
+			name:  "genre",
+			query: Query{Filters: models.PostFilters{Genres: []string{"drama"}}},
+			want: map[string][]models.Facet{
+				FieldGenre:    {{Value: "Drama", Count: 4}, {Value: "Ação", Count: 2}, {Value: "Comédia", Count: 2}, {Value: "Crime", Count: 2}},
+				FieldCountry:  {{Value: "Brasil", Count: 3}, {Value: "EUA", Count: 1}},
+				FieldLanguage: {{Value: "Português", Count: 3}, {Value: "Inglês", Count: 1}},
+				FieldSubtitle: {{Value: "Inglês", Count: 1}, {Value: "Português", Count: 1}},
+				FieldTag:      {{Value: "Favela", Count: 1}, {Value: "Épico", Count: 1}},
+				FieldYear:     {{Value: "2002-2005", Count: 1}, {Value: "2002", Count: 1}, {Value: "1998", Count: 1}, {Value: "1995", Count: 1}},
+			},
+		},
+		{
+			// the years are counted as if no year was chosen
+			name:  "year and channel",
+			query: Query{Channels: []int64{2}, Filters: models.PostFilters{YearFrom: 2000}},
+			want: map[string][]models.Facet{
+				FieldGenre:    {{Value: "Comédia", Count: 1}, {Value: "Drama", Count: 1}},
+				FieldCountry:  {{Value: "Brasil", Count: 2}},
+				FieldLanguage: {{Value: "Português", Count: 2}},
+				FieldSubtitle: {{Value: "Espanhol", Count: 1}, {Value: "Inglês", Count: 1}},
+				FieldTag:      {},
+				FieldYear:     {{Value: "2002-2005", Count: 1}, {Value: "2000", Count: 1}, {Value: "1993", Count: 1}},
+			},
+		},
+		{
+			name:  "search",
+			query: Query{Search: "cidade de deus"},
+			want: map[string][]models.Facet{
+				FieldGenre:    {{Value: "Crime", Count: 2}, {Value: "Drama", Count: 1}},
+				FieldCountry:  {{Value: "Brasil", Count: 2}},
+				FieldLanguage: {{Value: "Português", Count: 2}},
+				FieldSubtitle: {{Value: "Inglês", Count: 2}},
+				FieldTag:      {{Value: "Favela", Count: 1}},
+				FieldYear:     {{Value: "2002", Count: 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := idx.Facets(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Facets() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Facets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,556 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+
+	_ "github.com/glebarez/go-sqlite"
+	"go.uber.org/zap"
+)
+
+var index *Index
+
+var ErrNotFound = errors.New("post not found in index")
+
+// Fields of MovieData stored as terms, which posts can be filtered by.
+const (
+	FieldGenre    = "genre"
+	FieldCountry  = "country"
+	FieldLanguage = "language"
+	FieldSubtitle = "subtitle"
+	FieldDirector = "director"
+	FieldWriter   = "writer"
+	FieldCast     = "cast"
+	FieldTag      = "tag"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS posts (
+	id                  INTEGER PRIMARY KEY,
+	channel_id          INTEGER NOT NULL,
+	message_id          INTEGER NOT NULL,
+	grouped_id          INTEGER NOT NULL DEFAULT 0,
+	date                INTEGER NOT NULL DEFAULT 0,
+	title               TEXT    NOT NULL DEFAULT '',
+	release_year        TEXT    NOT NULL DEFAULT '',
+	document_message_id INTEGER NOT NULL DEFAULT 0,
+	document_size       INTEGER NOT NULL DEFAULT 0,
+	image_hash          TEXT    NOT NULL DEFAULT '',
+	document_hash       TEXT    NOT NULL DEFAULT '',
+	sort_title          TEXT    NOT NULL DEFAULT '',
+	reaction_count      INTEGER NOT NULL DEFAULT 0,
+	data                TEXT    NOT NULL,
+	UNIQUE (channel_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS posts_date ON posts (date, channel_id, message_id);
+CREATE INDEX IF NOT EXISTS posts_document_message_id ON posts (channel_id, document_message_id);
+CREATE INDEX IF NOT EXISTS posts_sort_title ON posts (sort_title, channel_id, message_id);
+CREATE INDEX IF NOT EXISTS posts_document_size ON posts (document_size, channel_id, message_id);
+CREATE INDEX IF NOT EXISTS posts_reaction_count ON posts (reaction_count, channel_id, message_id);
+
+CREATE TABLE IF NOT EXISTS post_files (
+	channel_id INTEGER NOT NULL,
+	message_id INTEGER NOT NULL,
+	post_id    INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	hash       TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (channel_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS post_files_post ON post_files (post_id);
+
+CREATE TABLE IF NOT EXISTS post_terms (
+	post_id    INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	field      TEXT    NOT NULL,
+	value      TEXT    NOT NULL,
+	normalized TEXT    NOT NULL,
+	PRIMARY KEY (post_id, field, normalized)
+);
+CREATE INDEX IF NOT EXISTS post_terms_field ON post_terms (field, normalized);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_search USING fts5 (
+	title, directors, actors, tags, synopsis,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// postColumns are the columns scanPost reads. The hashes of the files of a
+// post are kept apart, since they're left out of its JSON.
+const postColumns = `posts.channel_id, posts.image_hash, posts.document_hash, posts.data,
+	(SELECT json_group_object(message_id, hash) FROM post_files WHERE post_id = posts.id)`
+
+// Index is a local SQLite copy of the posts of the channels, built by the
+// backfill job and used to serve listings without walking the history.
+// Posts are identified by their channel and message.
+type Index struct {
+	db  *sql.DB
+	log *zap.Logger
+
+	mu    sync.RWMutex
+	ready map[int64]bool
+}
+
+// Term is a value of a field of the MovieData of a post.
+type Term struct {
+	Field string
+	Value string
+}
+
+type Query struct {
+	// Channels only returns posts of these channels, all of them when empty.
+	Channels []int64
+	// OffsetID only returns posts older than this message, 0 for the newest.
+	OffsetID int
+	// Sort is the order of the posts, SortNewest by default and
+	// SortRelevance when searching.
+	Sort string
+	// From only returns the posts after this one in Sort, or before it,
+	// the closest first, with From.Before. Ignored for SortRelevance.
+	From *Position
+	// Offset skips this many posts, used to page through search results
+	// ranked by relevance.
+	Offset  int
+	Limit   int
+	Filters models.PostFilters
+	// Search only returns posts matching all of its words, most relevant
+	// first.
+	Search string
+}
+
+// BackfillState is how far the backfill job walked the history of a channel.
+type BackfillState struct {
+	OffsetID int
+	Done     bool
+}
+
+func InitIndex(log *zap.Logger) error {
+	log = log.Named("index")
+
+	if config.ValueOf.IndexPath == "" {
+		log.Sugar().Info("INDEX_PATH not set, local index disabled")
+		return nil
+	}
+
+	dsn := config.ValueOf.IndexPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open index: %w", err)
+	}
+	// SQLite allows a single writer, so writes are serialized here instead
+	// of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(context.Background(), db, log); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to migrate index: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to create index schema: %w", err)
+	}
+
+	index = &Index{db: db, log: log, ready: make(map[int64]bool)}
+
+	if err := index.loadReady(context.Background()); err != nil {
+		_ = db.Close()
+		index = nil
+		return err
+	}
+
+	log.Sugar().Infof("initialized at %s", config.ValueOf.IndexPath)
+	return nil
+}
+
+// GetIndex returns the local index, or nil when it's disabled.
+func GetIndex() *Index {
+	return index
+}
+
+// Ready reports whether the whole history of every one of channelIDs has
+// been indexed, so their listings can be served from the index alone.
+func (i *Index) Ready(channelIDs ...int64) bool {
+	if i == nil || len(channelIDs) == 0 {
+		return false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, id := range channelIDs {
+		if !i.ready[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *Index) UpsertPosts(ctx context.Context, posts []models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, post := range posts {
+		if err := upsertPost(ctx, tx, post); err != nil {
+			return fmt.Errorf("failed to index post %d of channel %d: %w", post.MessageID, post.ChannelID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func upsertPost(ctx context.Context, tx *sql.Tx, post models.Post) error {
+	// media URLs depend on the host and on link expiry, and the channel slug
+	// on the configuration, so they're set again whenever the post is served
+	post.Channel = ""
+	post.ImageURL, post.VideoURL, post.DownloadURL = "", "", ""
+	post.Files = slices.Clone(post.Files)
+	for i := range post.Files {
+		post.Files[i].VideoURL, post.Files[i].DownloadURL = "", ""
+	}
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO posts (channel_id, message_id, grouped_id, date, title, release_year, document_message_id, document_size, image_hash, document_hash, sort_title, reaction_count, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (channel_id, message_id) DO UPDATE SET
+			grouped_id = excluded.grouped_id,
+			date = excluded.date,
+			title = excluded.title,
+			release_year = excluded.release_year,
+			document_message_id = excluded.document_message_id,
+			document_size = excluded.document_size,
+			image_hash = excluded.image_hash,
+			document_hash = excluded.document_hash,
+			sort_title = excluded.sort_title,
+			reaction_count = excluded.reaction_count,
+			data = excluded.data
+		RETURNING id`,
+		post.ChannelID, post.MessageID, post.GroupedID, post.Date, post.ParsedContent.Title, post.ParsedContent.ReleaseDate,
+		post.DocumentMessageID, post.DocumentSize, post.ImageHash, post.DocumentHash,
+		SortKey(SortTitle, &post), SortKey(SortReactions, &post), string(data),
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_files WHERE post_id = ?`, id); err != nil {
+		return err
+	}
+	for _, file := range post.Files {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO post_files (channel_id, message_id, post_id, hash) VALUES (?, ?, ?, ?)`,
+			post.ChannelID, file.MessageID, id, file.Hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_terms WHERE post_id = ?`, id); err != nil {
+		return err
+	}
+	if err := indexSearch(ctx, tx, id, &post); err != nil {
+		return err
+	}
+
+	for _, term := range postTerms(&post.ParsedContent) {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO post_terms (post_id, field, value, normalized) VALUES (?, ?, ?, ?)`,
+			id, term.Field, term.Value, Normalize(term.Value),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postTerms(data *models.MovieData) []Term {
+	var terms []Term
+	add := func(field string, values []string) {
+		for _, value := range values {
+			if value = strings.Join(strings.Fields(value), " "); value != "" {
+				terms = append(terms, Term{Field: field, Value: value})
+			}
+		}
+	}
+	add(FieldGenre, data.Genres)
+	add(FieldCountry, data.CountryOfOrigin)
+	add(FieldLanguage, data.Languages)
+	add(FieldSubtitle, data.Subtitles)
+	add(FieldDirector, data.Directors)
+	add(FieldWriter, data.Writers)
+	add(FieldCast, data.Cast)
+	add(FieldTag, data.Tags)
+	return terms
+}
+
+func (i *Index) DeletePosts(ctx context.Context, channelID int64, messageIDs ...int) error {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, messageID := range messageIDs {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM posts_search WHERE rowid IN (SELECT id FROM posts WHERE channel_id = ? AND message_id = ?)`,
+			channelID, messageID,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE channel_id = ? AND message_id = ?`, channelID, messageID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (i *Index) GetPost(ctx context.Context, channelID int64, messageID int) (*models.Post, error) {
+	row := i.db.QueryRowContext(ctx,
+		`SELECT `+postColumns+` FROM posts WHERE channel_id = ? AND message_id = ?`,
+		channelID, messageID,
+	)
+	post, err := scanPost(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return post, err
+}
+
+// PostsWithMessages returns the posts of the channel whose caption or one
+// of whose files is one of messageIDs.
+func (i *Index) PostsWithMessages(ctx context.Context, channelID int64, messageIDs []int) ([]models.Post, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	ids := placeholders(len(messageIDs))
+	args := make([]any, 0, len(messageIDs)*3+2)
+	args = append(args, channelID)
+	for range 2 {
+		for _, id := range messageIDs {
+			args = append(args, id)
+		}
+	}
+	args = append(args, channelID)
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+
+	return i.queryPosts(ctx, fmt.Sprintf(
+		"SELECT %s FROM posts WHERE channel_id = ? AND (message_id IN (%s) OR document_message_id IN (%s)"+
+			" OR id IN (SELECT post_id FROM post_files WHERE channel_id = ? AND message_id IN (%s)))",
+		postColumns, ids, ids, ids,
+	), args...)
+}
+
+// ListPosts returns the posts matching query in its order. With
+// From.Before they're in reverse, the closest to From first.
+func (i *Index) ListPosts(ctx context.Context, query Query) ([]models.Post, error) {
+	joins, where, args, ok := query.conditions("")
+	if !ok {
+		return nil, nil
+	}
+
+	stmt := "SELECT " + postColumns + " FROM posts" + joins
+	order, from, fromArgs := query.orderBy()
+	if from != "" {
+		where = append(where, from)
+		args = append(args, fromArgs...)
+	}
+	if query.OffsetID > 0 {
+		where = append(where, "posts.message_id < ?")
+		args = append(args, query.OffsetID)
+	}
+
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY " + order
+	if query.Limit > 0 || query.Offset > 0 {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, limit, query.Offset)
+	}
+
+	return i.queryPosts(ctx, stmt, args...)
+}
+
+// CountPosts returns the number of posts matching query, regardless of its
+// position and limit.
+func (i *Index) CountPosts(ctx context.Context, query Query) (int, error) {
+	joins, where, args, ok := query.conditions("")
+	if !ok {
+		return 0, nil
+	}
+
+	stmt := "SELECT COUNT(*) FROM posts" + joins
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var count int
+	err := i.db.QueryRowContext(ctx, stmt, args...).Scan(&count)
+	return count, err
+}
+
+func (i *Index) queryPosts(ctx context.Context, stmt string, args ...any) ([]models.Post, error) {
+	rows, err := i.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *post)
+	}
+	return posts, rows.Err()
+}
+
+// MaxMessageID returns the newest message, caption or document, of the
+// channel in the index.
+func (i *Index) MaxMessageID(ctx context.Context, channelID int64) (int, error) {
+	var id sql.NullInt64
+	err := i.db.QueryRowContext(ctx, `
+		SELECT MAX(id) FROM (
+			SELECT MAX(message_id, document_message_id) AS id FROM posts WHERE channel_id = ?
+			UNION ALL SELECT message_id FROM post_files WHERE channel_id = ?
+		)`, channelID, channelID).Scan(&id)
+	return int(id.Int64), err
+}
+
+func (i *Index) BackfillState(ctx context.Context, channelID int64) (BackfillState, error) {
+	var state BackfillState
+	offsetKey, doneKey := backfillKeys(channelID)
+
+	rows, err := i.db.QueryContext(ctx, `SELECT key, value FROM meta WHERE key IN (?, ?)`, offsetKey, doneKey)
+	if err != nil {
+		return state, fmt.Errorf("failed to read backfill state: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return state, err
+		}
+		switch key {
+		case offsetKey:
+			state.OffsetID, _ = strconv.Atoi(value)
+		case doneKey:
+			state.Done = value == "1"
+		}
+	}
+	return state, rows.Err()
+}
+
+func (i *Index) SaveBackfillState(ctx context.Context, channelID int64, state BackfillState) error {
+	done := "0"
+	if state.Done {
+		done = "1"
+	}
+	offsetKey, doneKey := backfillKeys(channelID)
+
+	_, err := i.db.ExecContext(ctx, `
+		INSERT INTO meta (key, value) VALUES (?, ?), (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		offsetKey, strconv.Itoa(state.OffsetID), doneKey, done,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save backfill state: %w", err)
+	}
+
+	i.mu.Lock()
+	i.ready[channelID] = state.Done
+	i.mu.Unlock()
+	return nil
+}
+
+// loadReady reads which channels have been backfilled.
+func (i *Index) loadReady(ctx context.Context) error {
+	rows, err := i.db.QueryContext(ctx, `SELECT key FROM meta WHERE key LIKE 'backfill_done:%' AND value = '1'`)
+	if err != nil {
+		return fmt.Errorf("failed to read backfill state: %w", err)
+	}
+	defer rows.Close()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(key, "backfill_done:"), 10, 64)
+		if err == nil {
+			i.ready[id] = true
+		}
+	}
+	return rows.Err()
+}
+
+func backfillKeys(channelID int64) (offsetKey, doneKey string) {
+	return fmt.Sprintf("backfill_offset_id:%d", channelID), fmt.Sprintf("backfill_done:%d", channelID)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPost(row scanner) (*models.Post, error) {
+	var channelID int64
+	var imageHash, documentHash, data, fileHashes string
+	if err := row.Scan(&channelID, &imageHash, &documentHash, &data, &fileHashes); err != nil {
+		return nil, err
+	}
+
+	var post models.Post
+	if err := json.Unmarshal([]byte(data), &post); err != nil {
+		return nil, fmt.Errorf("failed to decode indexed post: %w", err)
+	}
+	post.ChannelID = channelID
+	post.ImageHash = imageHash
+	post.DocumentHash = documentHash
+
+	hashes := make(map[string]string)
+	if err := json.Unmarshal([]byte(fileHashes), &hashes); err != nil {
+		return nil, fmt.Errorf("failed to decode file hashes: %w", err)
+	}
+	for i := range post.Files {
+		post.Files[i].Hash = hashes[strconv.Itoa(post.Files[i].MessageID)]
+	}
+
+	return &post, nil
+}
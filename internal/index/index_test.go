@@ -0,0 +1,328 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// newTestIndex opens an empty index in a temporary directory, in place of
+// the one of the package while the test runs.
+func newTestIndex(t *testing.T) *Index {
+	t.Helper()
+
+	path := config.ValueOf.IndexPath
+	config.ValueOf.IndexPath = filepath.Join(t.TempDir(), "index.db")
+	if err := InitIndex(zap.NewNop()); err != nil {
+		t.Fatalf("InitIndex() error = %v", err)
+	}
+
+	idx := GetIndex()
+	t.Cleanup(func() {
+		_ = idx.db.Close()
+		index = nil
+		config.ValueOf.IndexPath = path
+	})
+	return idx
+}
+
+// testPost is message messageID of channelID, with a document in the next
+// message and reactions adding up to reactions.
+func testPost(channelID int64, messageID, date int, size int64, reactions int, data models.MovieData) models.Post {
+	post := models.Post{
+		ChannelID:         channelID,
+		MessageID:         messageID,
+		Date:              date,
+		ParsedContent:     data,
+		DocumentSize:      size,
+		DocumentMessageID: messageID + 1,
+		ImageHash:         fmt.Sprintf("image-%d-%d", channelID, messageID),
+		DocumentHash:      fmt.Sprintf("document-%d-%d", channelID, messageID+1),
+		Files: []models.PostFile{{
+			MessageID: messageID + 1,
+			Size:      size,
+			Hash:      fmt.Sprintf("document-%d-%d", channelID, messageID+1),
+		}},
+	}
+	if reactions > 0 {
+		post.Reactions = []models.Reaction{{Reaction: "👍", Count: reactions - 1}, {Reaction: "❤", Count: 1}}
+	}
+	return post
+}
+
+// testPosts are the posts of two test channels. Several of them share a
+// date, title, release year, size or number of reactions, so that every
+// order has ties.
+func testPosts() []models.Post {
+	cidadeDeDeus := testPost(1, 10, 100, 700, 5, models.MovieData{
+		Title:           "Cidade de Deus",
+		ReleaseDate:     "2002",
+		Genres:          []string{"Crime", "Drama"},
+		CountryOfOrigin: []string{"Brasil"},
+		Languages:       []string{"Português"},
+		Subtitles:       []string{"Inglês"},
+		Tags:            []string{"Favela"},
+		Directors:       []string{"Fernando Meirelles"},
+		Writers:         []string{"Bráulio Mantovani"},
+		Cast:            []string{"Alexandre Rodrigues"},
+		Synopsis:        "Buscapé cresce em meio à violência",
+	})
+	cidadeDeDeus.Files = append(cidadeDeDeus.Files, models.PostFile{MessageID: 12, Size: 300, Hash: "document-1-12"})
+
+	return []models.Post{
+		cidadeDeDeus,
+		testPost(1, 20, 200, 700, 5, models.MovieData{
+			Title:           "Central do Brasil",
+			ReleaseDate:     "1998",
+			Genres:          []string{"Drama"},
+			CountryOfOrigin: []string{"Brasil"},
+			Languages:       []string{"Português"},
+			Directors:       []string{"Walter Salles"},
+			Cast:            []string{"Fernanda Montenegro"},
+			Synopsis:        "Uma ex-professora escreve cartas",
+		}),
+		testPost(1, 30, 200, 1500, 0, models.MovieData{
+			Title:           "Coração Valente",
+			ReleaseDate:     "1995",
+			Genres:          []string{"Ação", "Drama"},
+			CountryOfOrigin: []string{"EUA"},
+			Languages:       []string{"Inglês"},
+			Subtitles:       []string{"Português"},
+			Tags:            []string{"Épico"},
+			Directors:       []string{"Mel Gibson"},
+			Writers:         []string{"Randall Wallace"},
+			Cast:            []string{"Mel Gibson"},
+		}),
+		testPost(1, 40, 400, 700, 5, models.MovieData{
+			Title:           "Cidade de Deus",
+			Genres:          []string{"Crime"},
+			CountryOfOrigin: []string{"Brasil"},
+			Languages:       []string{"Português"},
+			Subtitles:       []string{"Inglês"},
+		}),
+		testPost(2, 10, 200, 700, 0, models.MovieData{
+			Title:           "Ação Mutante",
+			ReleaseDate:     "1993",
+			Genres:          []string{"Ação", "Comédia"},
+			CountryOfOrigin: []string{"Brasil"},
+			Languages:       []string{"Português"},
+			Tags:            []string{"Trash"},
+			Directors:       []string{"José Mojica"},
+		}),
+		testPost(2, 20, 300, 0, 12, models.MovieData{
+			Title:           "O Auto da Compadecida",
+			ReleaseDate:     "2000",
+			Genres:          []string{"Comédia"},
+			CountryOfOrigin: []string{"Brasil"},
+			Languages:       []string{"Português"},
+			Subtitles:       []string{"Inglês", "Espanhol"},
+			Directors:       []string{"Guel Arraes"},
+			Writers:         []string{"Ariano Suassuna"},
+			Cast:            []string{"Selton Mello", "Matheus Nachtergaele"},
+		}),
+		testPost(2, 30, 100, 1500, 0, models.MovieData{
+			Title:           "Cidade dos Homens",
+			ReleaseDate:     "2002-2005",
+			Genres:          []string{"Drama"},
+			CountryOfOrigin: []string{"Brasil"},
+			Languages:       []string{"Português"},
+			Directors:       []string{"Fernando Meirelles", "Paulo Morelli"},
+			Cast:            []string{"Douglas Silva"},
+		}),
+	}
+}
+
+// newTestPosts opens a test index holding testPosts.
+func newTestPosts(t *testing.T) *Index {
+	t.Helper()
+	idx := newTestIndex(t)
+	if err := idx.UpsertPosts(context.Background(), testPosts()); err != nil {
+		t.Fatalf("UpsertPosts() error = %v", err)
+	}
+	return idx
+}
+
+// postKeys returns the channel and message of posts, as "channel/message".
+func postKeys(posts []models.Post) []string {
+	var keys []string
+	for _, post := range posts {
+		keys = append(keys, fmt.Sprintf("%d/%d", post.ChannelID, post.MessageID))
+	}
+	return keys
+}
+
+func TestUpsertPosts(t *testing.T) {
+	ctx := context.Background()
+	idx := newTestIndex(t)
+
+	posts := testPosts()
+	// links are built again whenever a post is served
+	posts[0].Channel = "filmes"
+	posts[0].ImageURL = "http://localhost/api/v1/posts/images/10"
+	posts[0].Files[0].VideoURL = "http://localhost/api/v1/posts/videos/11"
+	if err := idx.UpsertPosts(ctx, posts); err != nil {
+		t.Fatalf("UpsertPosts() error = %v", err)
+	}
+
+	post, err := idx.GetPost(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("GetPost() error = %v", err)
+	}
+	if post.ChannelID != 1 || post.ParsedContent.Title != "Cidade de Deus" || post.DocumentSize != 700 {
+		t.Errorf("GetPost() = %+v, want the post of message 10 of channel 1", post)
+	}
+	if post.Channel != "" || post.ImageURL != "" || post.Files[0].VideoURL != "" {
+		t.Errorf("GetPost() kept the links of the post: %+v", post)
+	}
+	if post.ImageHash != "image-1-10" || post.DocumentHash != "document-1-11" {
+		t.Errorf("GetPost() hashes = %q, %q", post.ImageHash, post.DocumentHash)
+	}
+	if hashes := []string{post.Files[0].Hash, post.Files[1].Hash}; !slices.Equal(hashes, []string{"document-1-11", "document-1-12"}) {
+		t.Errorf("GetPost() file hashes = %v", hashes)
+	}
+
+	// the same message ID in another channel is another post
+	other, err := idx.GetPost(ctx, 2, 10)
+	if err != nil || other.ParsedContent.Title != "Ação Mutante" {
+		t.Errorf("GetPost(2, 10) = %+v, %v", other, err)
+	}
+	if _, err := idx.GetPost(ctx, 2, 40); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPost(2, 40) error = %v, want ErrNotFound", err)
+	}
+	if id, err := idx.MaxMessageID(ctx, 1); err != nil || id != 41 {
+		t.Errorf("MaxMessageID(1) = %d, %v, want 41", id, err)
+	}
+
+	withMessages := []struct {
+		name     string
+		channel  int64
+		messages []int
+		want     []string
+	}{
+		{name: "caption", channel: 1, messages: []int{30}, want: []string{"1/30"}},
+		{name: "document", channel: 1, messages: []int{11}, want: []string{"1/10"}},
+		{name: "other file", channel: 1, messages: []int{12}, want: []string{"1/10"}},
+		{name: "several", channel: 2, messages: []int{10, 31}, want: []string{"2/10", "2/30"}},
+		{name: "other channel", channel: 2, messages: []int{40}},
+		{name: "none", channel: 1},
+	}
+	for _, tt := range withMessages {
+		t.Run(tt.name, func(t *testing.T) {
+			posts, err := idx.PostsWithMessages(ctx, tt.channel, tt.messages)
+			if err != nil {
+				t.Fatalf("PostsWithMessages() error = %v", err)
+			}
+			keys := postKeys(posts)
+			slices.Sort(keys)
+			if !slices.Equal(keys, tt.want) {
+				t.Errorf("PostsWithMessages(%d, %v) = %v, want %v", tt.channel, tt.messages, keys, tt.want)
+			}
+		})
+	}
+
+	// an edit replaces the post, its files, terms and searchable text
+	edited := testPosts()[0]
+	edited.ParsedContent.Title = "Tropa de Elite"
+	edited.ParsedContent.Genres = []string{"Ação"}
+	edited.Files = edited.Files[:1]
+	if err := idx.UpsertPosts(ctx, []models.Post{edited}); err != nil {
+		t.Fatalf("UpsertPosts() error = %v", err)
+	}
+
+	if post, err := idx.GetPost(ctx, 1, 10); err != nil || post.ParsedContent.Title != "Tropa de Elite" {
+		t.Errorf("GetPost() after edit = %+v, %v", post, err)
+	}
+	if count, err := idx.CountPosts(ctx, Query{}); err != nil || count != 7 {
+		t.Errorf("CountPosts() after edit = %d, %v, want 7", count, err)
+	}
+	listed(t, idx, Query{Filters: models.PostFilters{Genres: []string{"Crime"}}}, "1/40")
+	listed(t, idx, Query{Filters: models.PostFilters{Genres: []string{"Ação"}}}, "2/10", "1/30", "1/10")
+	listed(t, idx, Query{Search: "deus"}, "1/40")
+	listed(t, idx, Query{Search: "tropa"}, "1/10")
+	if posts, err := idx.PostsWithMessages(ctx, 1, []int{12}); err != nil || len(posts) != 0 {
+		t.Errorf("PostsWithMessages() of a dropped file = %v, %v", postKeys(posts), err)
+	}
+
+	if err := idx.DeletePosts(ctx, 1, 10, 20); err != nil {
+		t.Fatalf("DeletePosts() error = %v", err)
+	}
+	if _, err := idx.GetPost(ctx, 1, 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPost() of a deleted post error = %v, want ErrNotFound", err)
+	}
+	if count, err := idx.CountPosts(ctx, Query{}); err != nil || count != 5 {
+		t.Errorf("CountPosts() after delete = %d, %v, want 5", count, err)
+	}
+	listed(t, idx, Query{Search: "tropa"})
+	listed(t, idx, Query{Filters: models.PostFilters{Genres: []string{"Ação"}}}, "2/10", "1/30")
+	if posts, err := idx.PostsWithMessages(ctx, 1, []int{11}); err != nil || len(posts) != 0 {
+		t.Errorf("PostsWithMessages() of a deleted post = %v, %v", postKeys(posts), err)
+	}
+	terms, _, err := idx.Terms(ctx, TermQuery{Fields: []string{FieldDirector}, Search: "walter"})
+	if err != nil || len(terms) != 0 {
+		t.Errorf("Terms() of a deleted post = %v, %v", terms, err)
+	}
+}
+
+// listed checks that query lists the posts want, in order, and counts as
+// many.
+func listed(t *testing.T, idx *Index, query Query, want ...string) {
+	t.Helper()
+
+	posts, err := idx.ListPosts(context.Background(), query)
+	if err != nil {
+		t.Fatalf("ListPosts() error = %v", err)
+	}
+	if keys := postKeys(posts); !slices.Equal(keys, want) {
+		t.Errorf("ListPosts(%+v) = %v, want %v", query, keys, want)
+	}
+
+	count, err := idx.CountPosts(context.Background(), query)
+	if err != nil {
+		t.Fatalf("CountPosts() error = %v", err)
+	}
+	if count != len(want) {
+		t.Errorf("CountPosts(%+v) = %d, want %d", query, count, len(want))
+	}
+}
+
+func TestBackfillState(t *testing.T) {
+	ctx := context.Background()
+	idx := newTestIndex(t)
+
+	if idx.Ready(1) {
+		t.Error("channel ready before it was backfilled")
+	}
+	if err := idx.SaveBackfillState(ctx, 1, BackfillState{OffsetID: 500}); err != nil {
+		t.Fatalf("SaveBackfillState() error = %v", err)
+	}
+	if state, err := idx.BackfillState(ctx, 1); err != nil || state != (BackfillState{OffsetID: 500}) {
+		t.Errorf("BackfillState() = %+v, %v", state, err)
+	}
+	if idx.Ready(1) {
+		t.Error("channel ready part way through its backfill")
+	}
+
+	if err := idx.SaveBackfillState(ctx, 1, BackfillState{OffsetID: 1, Done: true}); err != nil {
+		t.Fatalf("SaveBackfillState() error = %v", err)
+	}
+	if !idx.Ready(1) || idx.Ready(1, 2) || idx.Ready() {
+		t.Error("Ready() = true only for channel 1 alone")
+	}
+
+	// the state outlives the process
+	idx.ready = make(map[int64]bool)
+	if err := idx.loadReady(ctx); err != nil {
+		t.Fatalf("loadReady() error = %v", err)
+	}
+	if !idx.Ready(1) {
+		t.Error("backfilled channel not ready after reloading")
+	}
+}
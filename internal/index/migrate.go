@@ -0,0 +1,44 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// schemaVersion is kept in the user_version of the database, and raised
+// whenever the schema changes in a way that can't be migrated in place.
+// Version 2 keys posts by channel and message.
+const schemaVersion = 2
+
+// migrate drops an index built with an older schema. It's only a copy of
+// the channels, so the backfill job builds it again from their history,
+// while listings are served from Telegram in the meantime.
+func migrate(ctx context.Context, db *sql.DB, log *zap.Logger) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	var tables int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'posts'`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables > 0 {
+		log.Sugar().Infof("index schema changed to version %d, rebuilding it from the channel history", schemaVersion)
+		for _, table := range []string{"posts_search", "post_terms", "post_files", "posts", "meta"} {
+			if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS `+table); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion))
+	return err
+}
@@ -0,0 +1,21 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize folds case and strips accents and extra whitespace, so that
+// "Ação", "acao" and " AÇÃO " all match the same term.
+func Normalize(value string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, value)
+	if err != nil {
+		folded = value
+	}
+	return strings.ToLower(strings.Join(strings.Fields(folded), " "))
+}
@@ -0,0 +1,45 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+
+	"go-winx-api/internal/models"
+)
+
+// searchRank orders search results by relevance. Matches in the title
+// weigh the most, then people and tags, then the synopsis.
+const searchRank = "bm25(posts_search, 10.0, 4.0, 4.0, 2.0, 1.0)"
+
+// indexSearch replaces the searchable text of post, whose row in posts is id.
+func indexSearch(ctx context.Context, tx *sql.Tx, id int64, post *models.Post) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM posts_search WHERE rowid = ?`, id); err != nil {
+		return err
+	}
+
+	data := &post.ParsedContent
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO posts_search (rowid, title, directors, actors, tags, synopsis) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, data.Title,
+		strings.Join(data.Directors, ", "), strings.Join(data.Cast, ", "),
+		strings.Join(data.Tags, ", "), data.Synopsis,
+	)
+	return err
+}
+
+// matchExpression turns free text into an FTS5 query that matches posts
+// containing every word, or a word starting with it. Accents and case are
+// ignored by the tokenizer.
+func matchExpression(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,57 @@
+package index
+
+import "testing"
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{search: "", want: ""},
+		{search: "cidade", want: `"cidade"*`},
+		{search: "Cidade de Deus", want: `"Cidade"* "de"* "Deus"*`},
+		{search: "  coração   valente ", want: `"coração"* "valente"*`},
+		{search: "ação-2", want: `"ação"* "2"*`},
+		{search: `o "auto"`, want: `"o"* "auto"*`},
+		{search: "AND OR NOT", want: `"AND"* "OR"* "NOT"*`},
+		{search: "title:deus*", want: `"title"* "deus"*`},
+		{search: "!!! ...", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			if got := matchExpression(tt.search); got != tt.want {
+				t.Errorf("matchExpression(%q) = %q, want %q", tt.search, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	idx := newTestPosts(t)
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{search: "coracao", want: []string{"1/30"}},
+		{search: "CORAÇÃO Valente", want: []string{"1/30"}},
+		{search: "acao", want: []string{"2/10"}},
+		{search: "cida", want: []string{"1/40", "2/30", "1/10"}},
+		{search: "cidade deus", want: []string{"1/40", "1/10"}},
+		{search: "cidade homens", want: []string{"2/30"}},
+		{search: "meirelles", want: []string{"2/30", "1/10"}},
+		{search: "selton", want: []string{"2/20"}},
+		{search: "epico", want: []string{"1/30"}},
+		{search: "violencia", want: []string{"1/10"}},
+		{search: "cidade valente"},
+		{search: "!!!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			// newest first, so that posts that match as well keep an order
+			listed(t, idx, Query{Search: tt.search, Sort: SortNewest}, tt.want...)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package index
+
+import (
+	"strconv"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+// Orders posts can be listed in.
+const (
+	SortNewest    = "newest"
+	SortOldest    = "oldest"
+	SortTitle     = "title"
+	SortYear      = "year"
+	SortSize      = "size"
+	SortReactions = "reactions"
+	// SortRelevance ranks search results, and is the default when searching.
+	SortRelevance = "relevance"
+)
+
+// sortOrder orders posts by column, then by channel and message so that
+// posts with the same value keep a stable order across pages.
+type sortOrder struct {
+	column string
+	desc   bool
+}
+
+// posts are ordered by date rather than message, since message ids are only
+// in order within a channel
+var sortOrders = map[string]sortOrder{
+	SortNewest:    {column: "posts.date", desc: true},
+	SortOldest:    {column: "posts.date"},
+	SortTitle:     {column: "posts.sort_title"},
+	SortYear:      {column: "CAST(posts.release_year AS INTEGER)", desc: true},
+	SortSize:      {column: "posts.document_size", desc: true},
+	SortReactions: {column: "posts.reaction_count", desc: true},
+}
+
+// Position is the post a page starts after, or ends before with Before,
+// in the order of a query.
+type Position struct {
+	// Key is the value the post is sorted by, from SortKey.
+	Key       any
+	ChannelID int64
+	MessageID int
+	Before    bool
+}
+
+// ValidSort reports whether posts can be listed in the order sort.
+func ValidSort(sort string) bool {
+	_, ok := sortOrders[sort]
+	return ok || sort == SortRelevance
+}
+
+// SortKey returns the value post is sorted by in the order sort, or nil
+// for SortRelevance.
+func SortKey(sort string, post *models.Post) any {
+	switch sort {
+	case SortNewest, SortOldest:
+		return post.Date
+	case SortTitle:
+		return Normalize(post.ParsedContent.Title)
+	case SortYear:
+		return releaseYear(post.ParsedContent.ReleaseDate)
+	case SortSize:
+		return post.DocumentSize
+	case SortReactions:
+		return reactionCount(post.Reactions)
+	}
+	return nil
+}
+
+// releaseYear reads the year posts are sorted by from their release date
+// the way SQLite casts it to an integer, from the leading digits after any
+// spaces and sign, so that "1999-2001" is 1999 and a date without any is 0.
+func releaseYear(date string) int {
+	date = strings.TrimLeft(date, " \t\n\r")
+	sign := 1
+	if date != "" && (date[0] == '-' || date[0] == '+') {
+		if date[0] == '-' {
+			sign = -1
+		}
+		date = date[1:]
+	}
+	end := 0
+	for end < len(date) && date[end] >= '0' && date[end] <= '9' {
+		end++
+	}
+	year, _ := strconv.Atoi(date[:end])
+	return sign * year
+}
+
+func reactionCount(reactions []models.Reaction) int {
+	count := 0
+	for _, reaction := range reactions {
+		count += reaction.Count
+	}
+	return count
+}
+
+// orderBy returns the ORDER BY clause of query, and the condition and
+// arguments selecting the posts past query.From.
+func (q Query) orderBy() (order string, where string, args []any) {
+	sort := q.Sort
+	if sort == "" {
+		sort = SortNewest
+		if q.Search != "" {
+			sort = SortRelevance
+		}
+	}
+	if sort == SortRelevance {
+		return searchRank + ", posts.date DESC, posts.channel_id DESC, posts.message_id DESC", "", nil
+	}
+
+	o, ok := sortOrders[sort]
+	if !ok {
+		o = sortOrders[SortNewest]
+	}
+
+	// pages before From are read backwards from it
+	desc := o.desc
+	if q.From != nil && q.From.Before {
+		desc = !desc
+	}
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	order = o.column + " " + dir + ", posts.channel_id " + dir + ", posts.message_id " + dir
+	if q.From != nil {
+		where = "(" + o.column + ", posts.channel_id, posts.message_id) " + cmp + " (?, ?, ?)"
+		args = []any{q.From.Key, q.From.ChannelID, q.From.MessageID}
+	}
+	return order, where, args
+}
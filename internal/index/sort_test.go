@@ -0,0 +1,89 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"testing"
+
+	"go-winx-api/internal/models"
+)
+
+func TestReleaseYear(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	dates := []string{"", "1999", "1999-2001", "2001/2002", " 2010", "2010 ", "1999.5", "c. 1950", "+2020", "-50", "anos 90", "-", "0042"}
+	for _, date := range dates {
+		var want int
+		if err := db.QueryRow("SELECT CAST(? AS INTEGER)", date).Scan(&want); err != nil {
+			t.Fatalf("failed to cast %q: %v", date, err)
+		}
+		if got := releaseYear(date); got != want {
+			t.Errorf("releaseYear(%q) = %d, SQLite casts it to %d", date, got, want)
+		}
+	}
+}
+
+func TestSortCursors(t *testing.T) {
+	ctx := context.Background()
+	idx := newTestPosts(t)
+
+	for _, sort := range slices.Sorted(maps.Keys(sortOrders)) {
+		t.Run(sort, func(t *testing.T) {
+			all, err := idx.ListPosts(ctx, Query{Sort: sort})
+			if err != nil {
+				t.Fatalf("ListPosts() error = %v", err)
+			}
+			if len(all) != len(testPosts()) {
+				t.Fatalf("ListPosts() = %v, want every post", postKeys(all))
+			}
+
+			for _, limit := range []int{1, 2, 3} {
+				// pages after the last post of the previous one
+				var forward []models.Post
+				query := Query{Sort: sort, Limit: limit}
+				for len(forward) <= len(all) {
+					page, err := idx.ListPosts(ctx, query)
+					if err != nil {
+						t.Fatalf("ListPosts() error = %v", err)
+					}
+					forward = append(forward, page...)
+					if len(page) < limit {
+						break
+					}
+					last := &page[len(page)-1]
+					query.From = &Position{Key: SortKey(sort, last), ChannelID: last.ChannelID, MessageID: last.MessageID}
+				}
+				if got, want := postKeys(forward), postKeys(all); !slices.Equal(got, want) {
+					t.Errorf("pages of %d = %v, want %v", limit, got, want)
+				}
+
+				// pages before the first post of the next one, from the end
+				var backward []models.Post
+				last := &all[len(all)-1]
+				query = Query{Sort: sort, Limit: limit, From: &Position{Key: SortKey(sort, last), ChannelID: last.ChannelID, MessageID: last.MessageID, Before: true}}
+				for len(backward) <= len(all) {
+					page, err := idx.ListPosts(ctx, query)
+					if err != nil {
+						t.Fatalf("ListPosts() error = %v", err)
+					}
+					backward = append(backward, page...)
+					if len(page) < limit {
+						break
+					}
+					first := &page[len(page)-1]
+					query.From = &Position{Key: SortKey(sort, first), ChannelID: first.ChannelID, MessageID: first.MessageID, Before: true}
+				}
+				slices.Reverse(backward)
+				if got, want := postKeys(backward), postKeys(all[:len(all)-1]); !slices.Equal(got, want) {
+					t.Errorf("pages of %d backwards = %v, want %v", limit, got, want)
+				}
+			}
+		})
+	}
+}
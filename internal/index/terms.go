@@ -0,0 +1,81 @@
+package index
+
+import (
+	"context"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+type TermQuery struct {
+	// Channels only counts posts of these channels, all of them when empty.
+	Channels []int64
+	// Fields are the fields whose values are listed together, so a person
+	// who directed one film and acted in another is counted once.
+	Fields []string
+	// Search only returns values containing it.
+	Search string
+	Offset int
+	Limit  int
+}
+
+// Terms lists the distinct values of the fields of query with the number of
+// posts that have each, the most common first. Values are grouped by their
+// normalized form. It also returns the total number of distinct values.
+func (i *Index) Terms(ctx context.Context, query TermQuery) ([]models.Term, int, error) {
+	where := []string{"field IN (" + placeholders(len(query.Fields)) + ")"}
+	args := make([]any, 0, len(query.Fields)+3)
+	for _, field := range query.Fields {
+		args = append(args, field)
+	}
+	if len(query.Channels) > 0 {
+		where = append(where, "post_id IN (SELECT id FROM posts WHERE channel_id IN ("+placeholders(len(query.Channels))+"))")
+		for _, id := range query.Channels {
+			args = append(args, id)
+		}
+	}
+	if search := Normalize(query.Search); search != "" {
+		where = append(where, "normalized LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(search)+"%")
+	}
+	conditions := strings.Join(where, " AND ")
+
+	var total int
+	err := i.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT normalized) FROM post_terms WHERE `+conditions, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT MIN(value), COUNT(DISTINCT post_id), GROUP_CONCAT(DISTINCT field) FROM post_terms
+		WHERE `+conditions+`
+		GROUP BY normalized
+		ORDER BY COUNT(DISTINCT post_id) DESC, normalized
+		LIMIT ? OFFSET ?`, append(args, limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	terms := make([]models.Term, 0)
+	for rows.Next() {
+		var term models.Term
+		var fields string
+		if err := rows.Scan(&term.Name, &term.Count, &fields); err != nil {
+			return nil, 0, err
+		}
+		if len(query.Fields) > 1 {
+			term.Roles = strings.Split(fields, ",")
+		}
+		terms = append(terms, term)
+	}
+	return terms, total, rows.Err()
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
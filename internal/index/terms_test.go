@@ -0,0 +1,85 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"go-winx-api/internal/models"
+)
+
+func TestTerms(t *testing.T) {
+	idx := newTestPosts(t)
+
+	tests := []struct {
+		name  string
+		query TermQuery
+		want  []models.Term
+		total int
+	}{
+		{
+			name:  "genres",
+			query: TermQuery{Fields: []string{FieldGenre}},
+			want:  []models.Term{{Name: "Drama", Count: 4}, {Name: "Ação", Count: 2}, {Name: "Comédia", Count: 2}, {Name: "Crime", Count: 2}},
+			total: 4,
+		},
+		{
+			name:  "page",
+			query: TermQuery{Fields: []string{FieldGenre}, Offset: 1, Limit: 2},
+			want:  []models.Term{{Name: "Ação", Count: 2}, {Name: "Comédia", Count: 2}},
+			total: 4,
+		},
+		{
+			name:  "channel",
+			query: TermQuery{Channels: []int64{2}, Fields: []string{FieldGenre}},
+			want:  []models.Term{{Name: "Comédia", Count: 2}, {Name: "Ação", Count: 1}, {Name: "Drama", Count: 1}},
+			total: 3,
+		},
+		{
+			name:  "search without accents",
+			query: TermQuery{Fields: []string{FieldGenre}, Search: "acao"},
+			want:  []models.Term{{Name: "Ação", Count: 2}},
+			total: 1,
+		},
+		{
+			name:  "search with accents",
+			query: TermQuery{Fields: []string{FieldTag}, Search: "ÉPI"},
+			want:  []models.Term{{Name: "Épico", Count: 1}},
+			total: 1,
+		},
+		{
+			name:  "search for a wildcard",
+			query: TermQuery{Fields: []string{FieldGenre}, Search: "%"},
+			want:  []models.Term{},
+		},
+		{
+			// a person who both directed and acted in a post counts once
+			name:  "people",
+			query: TermQuery{Fields: PeopleFields, Search: "mel"},
+			want:  []models.Term{{Name: "Mel Gibson", Count: 1, Roles: []string{FieldCast, FieldDirector}}, {Name: "Selton Mello", Count: 1, Roles: []string{FieldCast}}},
+			total: 2,
+		},
+		{
+			name:  "people in several posts",
+			query: TermQuery{Fields: PeopleFields, Limit: 1},
+			want:  []models.Term{{Name: "Fernando Meirelles", Count: 2, Roles: []string{FieldDirector}}},
+			total: 14,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := idx.Terms(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Terms() error = %v", err)
+			}
+			for i := range got {
+				slices.Sort(got[i].Roles)
+			}
+			if !reflect.DeepEqual(got, tt.want) || total != tt.total {
+				t.Errorf("Terms() = %v, %d, want %v, %d", got, total, tt.want, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+type ChunkCacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Entries   int     `json:"entries"`
+	Size      int64   `json:"size"`
+	MaxSize   int64   `json:"max_size"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Writes    uint64  `json:"writes"`
+	Evictions uint64  `json:"evictions"`
+	Corrupted uint64  `json:"corrupted"`
+}
+
+func (m *ChunkCacheStats) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":   m.Enabled,
+		"entries":   m.Entries,
+		"size":      m.Size,
+		"max_size":  m.MaxSize,
+		"hits":      m.Hits,
+		"misses":    m.Misses,
+		"hit_ratio": m.HitRatio,
+		"writes":    m.Writes,
+		"evictions": m.Evictions,
+		"corrupted": m.Corrupted,
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+// Channel is a Telegram channel posts are served from.
+type Channel struct {
+	// Slug names the channel in the API, as in /api/v1/channels/:slug.
+	Slug string `json:"slug"`
+	ID   int64  `json:"-"`
+	// Profile is the parser the captions of the channel are read with.
+	Profile string `json:"profile"`
+	// Token, when set, must be presented to read the channel.
+	Token      string `json:"-"`
+	Restricted bool   `json:"restricted"`
+}
+
+func (m *Channel) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"slug":       m.Slug,
+		"profile":    m.Profile,
+		"restricted": m.Restricted,
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of a page in a listing. It's handed to clients as
+// an opaque string, so its fields can change without breaking them.
+type Cursor struct {
+	// Sort is the order of the listing the cursor belongs to.
+	Sort string `json:"s,omitempty"`
+	// Channel, ID and Key are the channel, message and sort value of the
+	// post the page starts after, or ends before when Prev is set.
+	Channel int64 `json:"c,omitempty"`
+	ID      int   `json:"i,omitempty"`
+	Key     any   `json:"k,omitempty"`
+	Prev    bool  `json:"p,omitempty"`
+	// Offset is the number of results to skip in listings ranked by
+	// relevance, which can't be paged by message.
+	Offset int `json:"o,omitempty"`
+}
+
+func (m *Cursor) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"sort":    m.Sort,
+		"channel": m.Channel,
+		"id":      m.ID,
+		"key":     m.Key,
+		"prev":    m.Prev,
+		"offset":  m.Offset,
+	}
+}
+
+func (m Cursor) Encode() string {
+	data, _ := json.Marshal(m)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor made by Cursor.Encode. An empty string is
+// the first page.
+func ParseCursor(value string) (Cursor, error) {
+	var cursor Cursor
+	if value == "" {
+		return cursor, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID < 0 || cursor.Offset < 0 {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
@@ -14,6 +14,37 @@ type File struct {
 	FileName string
 	MimeType string
 	ID       int64
+	// MessageID is the channel message the document was fetched from.
+	MessageID int
+	Date      int
+}
+
+type Photo struct {
+	Location  *tg.InputPhotoFileLocation
+	FileSize  int64
+	MimeType  string
+	ID        int64
+	MessageID int
+	Date      int
+}
+
+func (f *File) Hash() string {
+	hash := &HashFileStruct{
+		FileName: f.FileName,
+		FileSize: f.FileSize,
+		MimeType: f.MimeType,
+		FileID:   f.ID,
+	}
+	return hash.Pack()
+}
+
+func (p *Photo) Hash() string {
+	hash := &HashFileStruct{
+		FileSize: p.FileSize,
+		MimeType: p.MimeType,
+		FileID:   p.ID,
+	}
+	return hash.Pack()
 }
 
 type HashFileStruct struct {
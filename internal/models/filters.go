@@ -0,0 +1,57 @@
+package models
+
+// PostFilters narrows a listing down to posts with any of the values given
+// for a field, and all of the fields given.
+type PostFilters struct {
+	Genres    []string `json:"genres,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Subtitles []string `json:"subtitles,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Directors []string `json:"directors,omitempty"`
+	Writers   []string `json:"writers,omitempty"`
+	Cast      []string `json:"cast,omitempty"`
+	// People matches a director, writer or cast member.
+	People       []string `json:"people,omitempty"`
+	YearFrom     int      `json:"year_from,omitempty"`
+	YearTo       int      `json:"year_to,omitempty"`
+	HasSubtitles *bool    `json:"has_subtitles,omitempty"`
+}
+
+func (m *PostFilters) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"genres":        m.Genres,
+		"countries":     m.Countries,
+		"languages":     m.Languages,
+		"subtitles":     m.Subtitles,
+		"tags":          m.Tags,
+		"directors":     m.Directors,
+		"writers":       m.Writers,
+		"cast":          m.Cast,
+		"people":        m.People,
+		"year_from":     m.YearFrom,
+		"year_to":       m.YearTo,
+		"has_subtitles": m.HasSubtitles,
+	}
+}
+
+// IsEmpty reports whether no filter is set.
+func (m *PostFilters) IsEmpty() bool {
+	return len(m.Genres) == 0 && len(m.Countries) == 0 && len(m.Languages) == 0 &&
+		len(m.Subtitles) == 0 && len(m.Tags) == 0 && len(m.Directors) == 0 &&
+		len(m.Writers) == 0 && len(m.Cast) == 0 && len(m.People) == 0 &&
+		m.YearFrom == 0 && m.YearTo == 0 && m.HasSubtitles == nil
+}
+
+// Facet is a value of a field and the number of posts that have it.
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func (m *Facet) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"value": m.Value,
+		"count": m.Count,
+	}
+}
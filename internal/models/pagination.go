@@ -13,6 +13,10 @@ type PaginationData struct {
 	MaxID         int       `json:"max_id,omitempty"`
 	MinID         int       `json:"min_id,omitempty"`
 	Search        string    `json:"search,omitempty"`
+	Sort          string    `json:"sort,omitempty"`
+	Cursor        string    `json:"cursor,omitempty"`
+	NextCursor    string    `json:"next_cursor,omitempty"`
+	PrevCursor    string    `json:"prev_cursor,omitempty"`
 }
 
 func (m *PaginationData) ToMap() map[string]interface{} {
@@ -27,5 +31,9 @@ func (m *PaginationData) ToMap() map[string]interface{} {
 		"max_id":          m.MaxID,
 		"min_id":          m.MinID,
 		"search":          m.Search,
+		"sort":            m.Sort,
+		"cursor":          m.Cursor,
+		"next_cursor":     m.NextCursor,
+		"prev_cursor":     m.PrevCursor,
 	}
 }
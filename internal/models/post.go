@@ -5,9 +5,60 @@ type Reaction struct {
 	Count    int    `json:"count"`
 }
 
+// FileInfo is what the name of a file tells about its contents.
+type FileInfo struct {
+	Quality string `json:"quality,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+	Part    int    `json:"part,omitempty"`
+}
+
+func (m *FileInfo) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"quality": m.Quality,
+		"season":  m.Season,
+		"episode": m.Episode,
+		"part":    m.Part,
+	}
+}
+
+// PostFile is a document of a post, such as an episode, a part of the movie
+// or a version in another quality.
+type PostFile struct {
+	MessageID   int    `json:"message_id"`
+	DocumentID  int64  `json:"document_id,omitempty"`
+	Size        int64  `json:"size"`
+	MimeType    string `json:"mime_type,omitempty"`
+	FileName    string `json:"file_name,omitempty"`
+	VideoURL    string `json:"video_url,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+	FileInfo
+	// Hash is the packed hash of the document, used to sign its links.
+	Hash string `json:"-"`
+}
+
+func (m *PostFile) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":   m.MessageID,
+		"document_id":  m.DocumentID,
+		"size":         m.Size,
+		"mime_type":    m.MimeType,
+		"file_name":    m.FileName,
+		"video_url":    m.VideoURL,
+		"download_url": m.DownloadURL,
+		"quality":      m.Quality,
+		"season":       m.Season,
+		"episode":      m.Episode,
+		"part":         m.Part,
+	}
+}
+
 type Post struct {
 	ImageURL          string     `json:"image_url,omitempty"`
 	VideoURL          string     `json:"video_url,omitempty"`
+	DownloadURL       string     `json:"download_url,omitempty"`
+	Channel           string     `json:"channel,omitempty"`
+	ChannelID         int64      `json:"-"`
 	GroupedID         int64      `json:"grouped_id,omitempty"`
 	MessageID         int        `json:"message_id"`
 	Date              int        `json:"date"`
@@ -18,12 +69,21 @@ type Post struct {
 	DocumentID        int64      `json:"document_id,omitempty"`
 	DocumentSize      int64      `json:"document_size,omitempty"`
 	DocumentMessageID int        `json:"document_message_id,omitempty"`
+	// Files are all the documents of the post, in the order they were
+	// sent. The Document fields describe the first of them.
+	Files []PostFile `json:"files,omitempty"`
+	// ImageHash and DocumentHash are the packed hashes of the post media,
+	// used to sign the media links.
+	ImageHash    string `json:"-"`
+	DocumentHash string `json:"-"`
 }
 
 func (m *Post) ToMap() map[string]interface{} {
 	return map[string]interface{}{
 		"image_url":           m.ImageURL,
 		"video_url":           m.VideoURL,
+		"download_url":        m.DownloadURL,
+		"channel":             m.Channel,
 		"grouped_id":          m.GroupedID,
 		"message_id":          m.MessageID,
 		"date":                m.Date,
@@ -34,17 +94,33 @@ func (m *Post) ToMap() map[string]interface{} {
 		"document_id":         m.DocumentID,
 		"document_size":       m.DocumentSize,
 		"document_message_id": m.DocumentMessageID,
+		"files":               m.Files,
+	}
+}
+
+// File returns the document of the post sent in messageID, or nil when it
+// isn't one of them.
+func (m *Post) File(messageID int) *PostFile {
+	for i := range m.Files {
+		if m.Files[i].MessageID == messageID {
+			return &m.Files[i]
+		}
 	}
+	return nil
 }
 
 type PaginatedPosts struct {
 	Data       []Post         `json:"data"`
 	Pagination PaginationData `json:"pagination"`
+	// Facets counts, for each filterable field, the posts matching the
+	// other filters that have each value.
+	Facets map[string][]Facet `json:"facets,omitempty"`
 }
 
 func (m *PaginatedPosts) ToMap() map[string]interface{} {
 	return map[string]interface{}{
 		"data":       m.Data,
 		"pagination": m.Pagination.ToMap(),
+		"facets":     m.Facets,
 	}
 }
@@ -0,0 +1,29 @@
+package models
+
+// Term is a person, genre, country or tag and the number of posts with it.
+type Term struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Roles lists the fields a person appears in: director, writer or cast.
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (m *Term) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  m.Name,
+		"count": m.Count,
+		"roles": m.Roles,
+	}
+}
+
+type PaginatedTerms struct {
+	Data       []Term         `json:"data"`
+	Pagination PaginationData `json:"pagination"`
+}
+
+func (m *PaginatedTerms) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"data":       m.Data,
+		"pagination": m.Pagination.ToMap(),
+	}
+}
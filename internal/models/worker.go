@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+type WorkerStatus struct {
+	ID                  int        `json:"id"`
+	Username            string     `json:"username"`
+	DC                  int        `json:"dc"`
+	Healthy             bool       `json:"healthy"`
+	InFlight            int64      `json:"in_flight"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	CircuitOpenUntil    *time.Time `json:"circuit_open_until,omitempty"`
+	FloodWaitUntil      *time.Time `json:"flood_wait_until,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastCheckAt         *time.Time `json:"last_check_at,omitempty"`
+}
+
+func (m *WorkerStatus) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":                   m.ID,
+		"username":             m.Username,
+		"dc":                   m.DC,
+		"healthy":              m.Healthy,
+		"in_flight":            m.InFlight,
+		"consecutive_failures": m.ConsecutiveFailures,
+		"circuit_open_until":   m.CircuitOpenUntil,
+		"flood_wait_until":     m.FloodWaitUntil,
+		"last_error":           m.LastError,
+		"last_error_at":        m.LastErrorAt,
+		"last_check_at":        m.LastCheckAt,
+	}
+}
+
+type WorkersStatus struct {
+	Workers         []WorkerStatus `json:"workers"`
+	PendingSessions int            `json:"pending_sessions"`
+}
+
+func (m *WorkersStatus) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"workers":          m.Workers,
+		"pending_sessions": m.PendingSessions,
+	}
+}
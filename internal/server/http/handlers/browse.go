@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"go-winx-api/internal/index"
+	"go-winx-api/internal/models"
+	"go-winx-api/internal/services/telegram"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// roles maps the role parameter of the people endpoints to the index fields.
+var roles = map[string]string{
+	"director": index.FieldDirector,
+	"writer":   index.FieldWriter,
+	"cast":     index.FieldCast,
+}
+
+func GetPeople(log *zap.Logger) fiber.Handler {
+	log = log.Named("people")
+
+	return func(c *fiber.Ctx) error {
+		fields := index.PeopleFields
+		if role := c.Query("role"); role != "" {
+			field, ok := roles[role]
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid 'role' parameter",
+				})
+			}
+			fields = []string{field}
+		}
+
+		return listTerms(c, log, fields)
+	}
+}
+
+func GetPersonPosts(log *zap.Logger) fiber.Handler {
+	log = log.Named("person_posts")
+
+	return func(c *fiber.Ctx) error {
+		channels, err := listingChannels(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		name, err := url.PathUnescape(c.Params("name"))
+		if err != nil || strings.TrimSpace(name) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid 'name' parameter",
+			})
+		}
+
+		pagination, err := parsePagination(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		filters, err := parseFilters(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		switch c.Query("role") {
+		case "":
+			filters.People = []string{name}
+		case "director":
+			filters.Directors = []string{name}
+		case "writer":
+			filters.Writers = []string{name}
+		case "cast":
+			filters.Cast = []string{name}
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid 'role' parameter",
+			})
+		}
+
+		if pagination.Sort, err = parseSort(c, ""); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		log.Info("Fetching posts of person", zap.String("name", name))
+
+		posts, err := telegram.PaginatePosts(c.UserContext(), log, channels, pagination, filters)
+		if errors.Is(err, telegram.ErrFiltersUnavailable) || errors.Is(err, telegram.ErrIndexUnavailable) {
+			return indexUnavailable(c, log, err)
+		}
+		if errors.Is(err, models.ErrInvalidCursor) {
+			return invalidCursor(c)
+		}
+		if err != nil {
+			log.Error("Failed to fetch posts", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch posts",
+			})
+		}
+
+		setPageLinks(c, posts.Pagination)
+		return c.JSON(posts)
+	}
+}
+
+func GetGenres(log *zap.Logger) fiber.Handler {
+	return browseTerms(log.Named("genres"), index.FieldGenre)
+}
+
+func GetCountries(log *zap.Logger) fiber.Handler {
+	return browseTerms(log.Named("countries"), index.FieldCountry)
+}
+
+func GetTags(log *zap.Logger) fiber.Handler {
+	return browseTerms(log.Named("tags"), index.FieldTag)
+}
+
+func browseTerms(log *zap.Logger, field string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return listTerms(c, log, []string{field})
+	}
+}
+
+// listTerms answers with the values of fields, optionally narrowed down by
+// the q parameter, and the number of posts with each.
+func listTerms(c *fiber.Ctx, log *zap.Logger, fields []string) error {
+	channels, err := listingChannels(c)
+	if err != nil {
+		return channelUnavailable(c, err)
+	}
+
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	terms, err := telegram.ListTerms(c.UserContext(), channels, fields, strings.TrimSpace(c.Query("q")), pagination)
+	if errors.Is(err, telegram.ErrIndexUnavailable) {
+		return indexUnavailable(c, log, err)
+	}
+	if err != nil {
+		log.Error("Failed to list terms", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list terms",
+		})
+	}
+
+	return c.JSON(terms)
+}
+
+func indexUnavailable(c *fiber.Ctx, log *zap.Logger, err error) error {
+	log.Warn("listing requested before the index is ready", zap.Error(err))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Not available until the local index is built",
+	})
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"go-winx-api/internal/cache"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func GetChunkCacheStats(log *zap.Logger) fiber.Handler {
+	log = log.Named("chunk_cache")
+
+	return func(c *fiber.Ctx) error {
+		log.Debug("Fetching chunk cache stats")
+		return c.JSON(cache.GetChunkCache().Stats())
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"go-winx-api/internal/models"
+	"go-winx-api/internal/services/telegram"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// errChannelForbidden is returned for a restricted channel requested
+// without its token.
+var errChannelForbidden = errors.New("channel requires a valid token")
+
+func GetChannels(log *zap.Logger) fiber.Handler {
+	log = log.Named("channels")
+
+	return func(c *fiber.Ctx) error {
+		log.Debug("Listing channels")
+		channels := telegram.Channels()
+		data := make([]models.Channel, 0, len(channels))
+		for _, channel := range channels {
+			data = append(data, *channel)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": data,
+		})
+	}
+}
+
+// routeChannel returns the channel of the :slug route parameter, or the
+// default channel on the routes without one.
+func routeChannel(c *fiber.Ctx) (*models.Channel, error) {
+	slug := c.Params("slug")
+	if slug == "" {
+		channel := telegram.DefaultChannel()
+		if !canAccess(c, channel) {
+			return nil, errChannelForbidden
+		}
+		return channel, nil
+	}
+
+	channel, err := telegram.ChannelBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccess(c, channel) {
+		return nil, errChannelForbidden
+	}
+	return channel, nil
+}
+
+// listingChannels returns the channel of the :slug route parameter or, on
+// the routes without one, every channel the request may read.
+func listingChannels(c *fiber.Ctx) ([]*models.Channel, error) {
+	if c.Params("slug") != "" {
+		channel, err := routeChannel(c)
+		if err != nil {
+			return nil, err
+		}
+		return []*models.Channel{channel}, nil
+	}
+
+	var channels []*models.Channel
+	for _, channel := range telegram.Channels() {
+		if canAccess(c, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		return nil, errChannelForbidden
+	}
+	return channels, nil
+}
+
+// canAccess reports whether the request may read channel, which for a
+// restricted channel takes its token in the X-Channel-Token header or the
+// token parameter, since media players can't set headers.
+func canAccess(c *fiber.Ctx, channel *models.Channel) bool {
+	if channel.Token == "" {
+		return true
+	}
+
+	token := c.Get("X-Channel-Token")
+	if token == "" {
+		token = c.Query("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(channel.Token)) == 1
+}
+
+func channelUnavailable(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errChannelForbidden) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or missing channel token",
+		})
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Channel not found",
+	})
+}
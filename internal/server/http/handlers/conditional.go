@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go-winx-api/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// photoETag returns a strong entity tag for the photo.
+func photoETag(photo *models.Photo) string {
+	return `"` + photo.Hash() + `"`
+}
+
+func setValidators(c *fiber.Ctx, etag string, lastModified time.Time) {
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+}
+
+// notModified evaluates If-None-Match and, when it is absent,
+// If-Modified-Since, as described in RFC 7232.
+func notModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	if ifModifiedSince := c.Get(fiber.HeaderIfModifiedSince); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// rangeAllowed evaluates If-Range: the Range header is only honored when the
+// validator it carries still matches the representation.
+func rangeAllowed(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	ifRange := c.Get(fiber.HeaderIfRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && date.Equal(lastModified.Truncate(time.Second))
+}
+
+// etagMatches uses the weak comparison required for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go-winx-api/internal/models"
+	"go-winx-api/internal/services/telegram"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// extensions covers the video and archive types posted to the channel, which
+// are often missing from the system MIME database.
+var extensions = map[string]string{
+	"video/mp4":                    ".mp4",
+	"video/x-matroska":             ".mkv",
+	"video/webm":                   ".webm",
+	"video/x-msvideo":              ".avi",
+	"video/quicktime":              ".mov",
+	"video/mpeg":                   ".mpeg",
+	"video/x-flv":                  ".flv",
+	"application/zip":              ".zip",
+	"application/x-rar-compressed": ".rar",
+	"application/vnd.rar":          ".rar",
+	"application/x-7z-compressed":  ".7z",
+}
+
+func GetPostDownload(log *zap.Logger) fiber.Handler {
+	log = log.Named("downloads")
+
+	return func(c *fiber.Ctx) error {
+		messageID, err := strconv.Atoi(c.Params("message_id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid 'message_id' parameter",
+			})
+		}
+
+		channel, err := routeChannel(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		log.Info("downloading file", zap.String("channel", channel.Slug), zap.Int("message_id", messageID))
+
+		if err := telegram.CheckMediaLink(channel, telegram.MediaDownloads, messageID, c.Query("hash"), c.Query("expires"), c.Query("signature")); err != nil {
+			return invalidMediaLink(c, log, err)
+		}
+
+		ctx := c.UserContext()
+
+		repository, err := telegram.NewChannelRepository(ctx, log, channel)
+		if err != nil {
+			return repositoryFailed(c, log, err)
+		}
+		defer repository.Close()
+
+		file, err := repository.GetFile(ctx, messageID)
+		if errors.Is(err, telegram.ErrPostNotFound) {
+			return mediaNotFound(c, log)
+		}
+		if err != nil {
+			log.Error("Failed to fetch file metadata", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch file metadata",
+			})
+		}
+
+		if err := telegram.VerifyLinkHash(file.Hash(), c.Query("hash")); err != nil {
+			return invalidMediaLink(c, log, err)
+		}
+
+		// a document with a name of its own is named even for HEAD, while a
+		// name built from its post is only looked up for the body
+		if name := sanitizeFileName(file.FileName); name != "" {
+			c.Set(fiber.HeaderContentDisposition, contentDisposition(name))
+			return sendFile(ctx, c, log, repository, file, nil)
+		}
+		return sendFile(ctx, c, log, repository, file, func() string {
+			return contentDisposition(downloadName(ctx, log, repository, file))
+		})
+	}
+}
+
+// downloadName returns the file name of the document or, when it has none,
+// one built from the title and release year of its post.
+func downloadName(ctx context.Context, log *zap.Logger, repository *telegram.Repository, file *models.File) string {
+	if name := sanitizeFileName(file.FileName); name != "" {
+		return name
+	}
+
+	name := strconv.Itoa(file.MessageID)
+	post, err := repository.GetDocumentPost(ctx, file.MessageID)
+	if err != nil {
+		log.Debug("no post found for document, using message id as name", zap.Error(err))
+	} else if title := sanitizeFileName(post.ParsedContent.Title); title != "" {
+		name = title
+		if year := post.ParsedContent.ReleaseDate; year != "" {
+			name = fmt.Sprintf("%s (%s)", title, year)
+		}
+	}
+
+	return name + extensionByType(file.MimeType)
+}
+
+func extensionByType(mimeType string) string {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// sanitizeFileName drops path separators and characters that aren't valid in
+// file names on common filesystems.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
+
+// contentDisposition builds an attachment header carrying the name both as a
+// plain ASCII fallback and RFC 5987 encoded, so non-ASCII titles survive.
+func contentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '\\' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encodeRFC5987(name))
+}
+
+func encodeRFC5987(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
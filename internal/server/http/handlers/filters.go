@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"go-winx-api/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// parseFilters reads the listing filters. A parameter may be repeated or
+// hold comma separated values, which match posts with any of them.
+func parseFilters(c *fiber.Ctx) (models.PostFilters, error) {
+	filters := models.PostFilters{
+		Genres:    queryValues(c, "genre"),
+		Countries: queryValues(c, "country"),
+		Languages: queryValues(c, "language"),
+		Subtitles: queryValues(c, "subtitle"),
+		Tags:      queryValues(c, "tag"),
+		Directors: queryValues(c, "director"),
+		Writers:   queryValues(c, "writer"),
+		Cast:      queryValues(c, "cast"),
+		People:    queryValues(c, "person"),
+	}
+
+	var err error
+	if filters.YearFrom, err = strconv.Atoi(c.Query("year_from", "0")); err != nil || filters.YearFrom < 0 {
+		return filters, errors.New("Invalid 'year_from' parameter")
+	}
+	if filters.YearTo, err = strconv.Atoi(c.Query("year_to", "0")); err != nil || filters.YearTo < 0 {
+		return filters, errors.New("Invalid 'year_to' parameter")
+	}
+
+	if value := c.Query("has_subtitles"); value != "" {
+		hasSubtitles, err := strconv.ParseBool(value)
+		if err != nil {
+			return filters, errors.New("Invalid 'has_subtitles' parameter")
+		}
+		filters.HasSubtitles = &hasSubtitles
+	}
+
+	return filters, nil
+}
+
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, raw := range c.Context().QueryArgs().PeekMulti(key) {
+		for _, value := range strings.Split(string(raw), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func filtersUnavailable(c *fiber.Ctx, log *zap.Logger, err error) error {
+	log.Warn("filters or sorting requested before the index is ready", zap.Error(err))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Filters and sorting are not available until the local index is built",
+	})
+}
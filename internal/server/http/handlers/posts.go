@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"go-winx-api/internal/index"
 	"go-winx-api/internal/models"
 	"go-winx-api/internal/services/telegram"
 
@@ -13,34 +18,55 @@ import (
 	"go.uber.org/zap"
 )
 
-func GetAllPosts(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
+func GetAllPosts(log *zap.Logger) fiber.Handler {
 	log = log.Named("posts")
 
 	return func(c *fiber.Ctx) error {
-		perPage, err := strconv.Atoi(c.Query("per_page", "10"))
+		channels, err := listingChannels(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		pagination, err := parsePagination(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid 'per_page' parameter",
+				"error": err.Error(),
 			})
 		}
 
-		offsetId, err := strconv.Atoi(c.Query("offset_id", "0"))
+		filters, err := parseFilters(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid 'offset_id' parameter",
+				"error": err.Error(),
 			})
 		}
 
-		log.Info("Fetching posts", zap.Int("per_page", perPage), zap.Int("offset_id", offsetId))
-
-		ctx := context.Background()
+		pagination.Search = strings.TrimSpace(c.Query("search"))
+		if pagination.Sort, err = parseSort(c, pagination.Search); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 
-		pagination := models.PaginationData{
-			PerPage:  perPage,
-			OffsetId: offsetId,
+		if pagination.Search != "" {
+			return searchPosts(c, log, channels, pagination, filters)
 		}
 
-		messages, err := repository.PaginatePosts(ctx, pagination)
+		log.Info("Fetching posts", zap.Int("per_page", pagination.PerPage), zap.Int("offset_id", pagination.OffsetId), zap.String("sort", pagination.Sort))
+
+		messages, err := telegram.PaginatePosts(c.UserContext(), log, channels, pagination, filters)
+		if errors.Is(err, telegram.ErrNoWorkers) {
+			return noWorkerAvailable(c, log, err)
+		}
+		if errors.Is(err, telegram.ErrFiltersUnavailable) {
+			return filtersUnavailable(c, log, err)
+		}
+		if errors.Is(err, telegram.ErrIndexUnavailable) {
+			return indexUnavailable(c, log, err)
+		}
+		if errors.Is(err, models.ErrInvalidCursor) {
+			return invalidCursor(c)
+		}
 		if err != nil {
 			log.Error("Failed to fetch posts", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -48,11 +74,95 @@ func GetAllPosts(log *zap.Logger, repository *telegram.Repository) fiber.Handler
 			})
 		}
 
+		setPageLinks(c, messages.Pagination)
 		return c.JSON(messages)
 	}
 }
 
-func GetPost(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
+// maxPerPage is the most items a page of a listing holds.
+const maxPerPage = 100
+
+// parsePagination reads the paging parameters shared by the listings. Pages
+// are capped at maxPerPage items.
+func parsePagination(c *fiber.Ctx) (models.PaginationData, error) {
+	perPage, err := strconv.Atoi(c.Query("per_page", "10"))
+	if err != nil || perPage < 1 {
+		return models.PaginationData{}, errors.New("Invalid 'per_page' parameter")
+	}
+	perPage = min(perPage, maxPerPage)
+
+	offsetId, err := strconv.Atoi(c.Query("offset_id", "0"))
+	if err != nil {
+		return models.PaginationData{}, errors.New("Invalid 'offset_id' parameter")
+	}
+
+	addOffset, err := strconv.Atoi(c.Query("add_offset", "0"))
+	if err != nil || addOffset < 0 {
+		return models.PaginationData{}, errors.New("Invalid 'add_offset' parameter")
+	}
+
+	cursor := c.Query("cursor")
+	if _, err := models.ParseCursor(cursor); err != nil {
+		return models.PaginationData{}, errors.New("Invalid 'cursor' parameter")
+	}
+
+	return models.PaginationData{
+		PerPage:   perPage,
+		OffsetId:  offsetId,
+		AddOffset: addOffset,
+		Cursor:    cursor,
+	}, nil
+}
+
+// parseSort reads the order of a post listing. Only searches can be
+// ranked by relevance.
+func parseSort(c *fiber.Ctx, search string) (string, error) {
+	sort := c.Query("sort")
+	if sort == "" {
+		return "", nil
+	}
+	if !index.ValidSort(sort) || (sort == index.SortRelevance && search == "") {
+		return "", errors.New("Invalid 'sort' parameter")
+	}
+	return sort, nil
+}
+
+// invalidCursor rejects a cursor that belongs to a listing in another order.
+func invalidCursor(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": "Invalid 'cursor' parameter",
+	})
+}
+
+// setPageLinks sets the Link header to the next and previous pages, which
+// are the request itself with their cursor in place of its position.
+func setPageLinks(c *fiber.Ctx, pagination models.PaginationData) {
+	var links []string
+	for _, page := range []struct{ rel, cursor string }{
+		{"next", pagination.NextCursor},
+		{"prev", pagination.PrevCursor},
+	} {
+		if page.cursor == "" {
+			continue
+		}
+
+		query := url.Values{}
+		c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+			query.Add(string(key), string(value))
+		})
+		query.Del("offset_id")
+		query.Del("add_offset")
+		query.Set("cursor", page.cursor)
+
+		links = append(links, fmt.Sprintf(`<%s%s?%s>; rel="%s"`, c.BaseURL(), c.Path(), query.Encode(), page.rel))
+	}
+
+	if len(links) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	}
+}
+
+func GetPost(log *zap.Logger) fiber.Handler {
 	log = log.Named("post")
 
 	return func(c *fiber.Ctx) error {
@@ -63,11 +173,22 @@ func GetPost(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
 			})
 		}
 
-		log.Info("Fetching post", zap.Int("id", messageId))
+		channel, err := routeChannel(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
 
-		ctx := context.Background()
+		log.Info("Fetching post", zap.String("channel", channel.Slug), zap.Int("id", messageId))
 
-		message, err := repository.GetPost(ctx, messageId)
+		message, err := telegram.GetPost(c.UserContext(), log, channel, messageId)
+		if errors.Is(err, telegram.ErrNoWorkers) {
+			return noWorkerAvailable(c, log, err)
+		}
+		if errors.Is(err, telegram.ErrPostNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
 		if err != nil {
 			log.Error("failed to fetch post", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -79,7 +200,7 @@ func GetPost(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
 	}
 }
 
-func GetPostImage(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
+func GetPostImage(log *zap.Logger) fiber.Handler {
 	log = log.Named("stream_images")
 
 	return func(c *fiber.Ctx) error {
@@ -90,13 +211,59 @@ func GetPostImage(log *zap.Logger, repository *telegram.Repository) fiber.Handle
 			})
 		}
 
-		log.Info("Streaming image", zap.Int("message_id", messageID))
+		channel, err := routeChannel(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		log.Info("Streaming image", zap.String("channel", channel.Slug), zap.Int("message_id", messageID))
+
+		if err := telegram.CheckMediaLink(channel, telegram.MediaImages, messageID, c.Query("hash"), c.Query("expires"), c.Query("signature")); err != nil {
+			return invalidMediaLink(c, log, err)
+		}
+
+		ctx := c.UserContext()
+
+		repository, err := telegram.NewChannelRepository(ctx, log, channel)
+		if err != nil {
+			return repositoryFailed(c, log, err)
+		}
+		defer repository.Close()
+
+		photo, err := repository.GetPhoto(ctx, messageID)
+		if errors.Is(err, telegram.ErrPostNotFound) {
+			return mediaNotFound(c, log)
+		}
+		if err != nil {
+			log.Error("failed to fetch image metadata", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch image metadata",
+			})
+		}
 
-		ctx := context.Background()
+		if err := telegram.VerifyLinkHash(photo.Hash(), c.Query("hash")); err != nil {
+			return invalidMediaLink(c, log, err)
+		}
 
-		c.Set("Content-Type", "image/jpeg")
+		etag := photoETag(photo)
+		lastModified := time.Unix(int64(photo.Date), 0)
+		setValidators(c, etag, lastModified)
 		c.Set("Cache-Control", "no-cache")
 
+		if notModified(c, etag, lastModified) {
+			c.Status(fiber.StatusNotModified)
+			return nil
+		}
+
+		c.Set("Content-Type", photo.MimeType)
+
+		if c.Method() == fiber.MethodHead {
+			if photo.FileSize > 0 {
+				c.Response().Header.SetContentLength(int(photo.FileSize))
+			}
+			return nil
+		}
+
 		if err := repository.GetPostImage(ctx, messageID, c.Response().BodyWriter()); err != nil {
 			log.Error("failed to stream image", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -108,7 +275,7 @@ func GetPostImage(log *zap.Logger, repository *telegram.Repository) fiber.Handle
 	}
 }
 
-func GetPostVideo(log *zap.Logger, repository *telegram.Repository) fiber.Handler {
+func GetPostVideo(log *zap.Logger) fiber.Handler {
 	log = log.Named("stream_videos")
 
 	return func(c *fiber.Ctx) error {
@@ -119,9 +286,29 @@ func GetPostVideo(log *zap.Logger, repository *telegram.Repository) fiber.Handle
 			})
 		}
 
-		log.Info("streaming video", zap.Int("message_id", messageID))
+		channel, err := routeChannel(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		log.Info("streaming video", zap.String("channel", channel.Slug), zap.Int("message_id", messageID))
 
-		file, err := repository.GetFile(context.Background(), messageID)
+		if err := telegram.CheckMediaLink(channel, telegram.MediaVideos, messageID, c.Query("hash"), c.Query("expires"), c.Query("signature")); err != nil {
+			return invalidMediaLink(c, log, err)
+		}
+
+		ctx := c.UserContext()
+
+		repository, err := telegram.NewChannelRepository(ctx, log, channel)
+		if err != nil {
+			return repositoryFailed(c, log, err)
+		}
+		defer repository.Close()
+
+		file, err := repository.GetFile(ctx, messageID)
+		if errors.Is(err, telegram.ErrPostNotFound) {
+			return mediaNotFound(c, log)
+		}
 		if err != nil {
 			log.Error("Failed to fetch file metadata", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -129,41 +316,128 @@ func GetPostVideo(log *zap.Logger, repository *telegram.Repository) fiber.Handle
 			})
 		}
 
-		rangeHeader := c.Get("Range")
-		var start, end int64
-		if rangeHeader != "" {
-			parts := strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), "-")
-			start, _ = strconv.ParseInt(parts[0], 10, 64)
-			if len(parts) > 1 && parts[1] != "" {
-				end, _ = strconv.ParseInt(parts[1], 10, 64)
-			} else {
-				end = file.FileSize - 1
-			}
-		} else {
-			start = 0
-			end = file.FileSize - 1
+		if err := telegram.VerifyLinkHash(file.Hash(), c.Query("hash")); err != nil {
+			return invalidMediaLink(c, log, err)
 		}
 
-		if end >= file.FileSize {
-			end = file.FileSize - 1
+		return sendFile(ctx, c, log, repository, file, nil)
+	}
+}
+
+// sendFile serves file with validators, conditional requests, HEAD and range
+// requests, streaming the selected bytes from Telegram. disposition, when
+// set, builds the Content-Disposition of responses with a body, as it may
+// need the post of the file.
+func sendFile(ctx context.Context, c *fiber.Ctx, log *zap.Logger, repository *telegram.Repository, file *models.File, disposition func() string) error {
+	etag := fileETag(file)
+	lastModified := time.Unix(int64(file.Date), 0)
+	c.Set("Accept-Ranges", "bytes")
+	setValidators(c, etag, lastModified)
+
+	if notModified(c, etag, lastModified) {
+		c.Status(fiber.StatusNotModified)
+		return nil
+	}
+
+	if c.Method() == fiber.MethodHead {
+		c.Set("Content-Type", file.MimeType)
+		c.Response().Header.SetContentLength(int(file.FileSize))
+		return nil
+	}
+
+	var ranges []byteRange
+	if rangeAllowed(c, etag, lastModified) {
+		var err error
+		ranges, err = parseRange(c.Get("Range"), file.FileSize)
+		if errors.Is(err, errUnsatisfiableRange) {
+			c.Set("Content-Range", fmt.Sprintf("bytes */%d", file.FileSize))
+			return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+				"error": "Requested range not satisfiable",
+			})
 		}
+	}
 
-		chunkSize := end - start + 1
+	if disposition != nil {
+		c.Set(fiber.HeaderContentDisposition, disposition())
+	}
 
-		c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
-		c.Set("Content-Length", fmt.Sprintf("%d", chunkSize))
-		c.Set("Accept-Ranges", "bytes")
+	if file.FileSize == 0 {
 		c.Set("Content-Type", file.MimeType)
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	if len(ranges) == 0 {
+		ranges = []byteRange{{start: 0, end: file.FileSize - 1}}
+		c.Status(fiber.StatusOK)
+	} else {
 		c.Status(fiber.StatusPartialContent)
+	}
 
-		stream, err := repository.GetPostVideo(context.Background(), file, start, end)
+	if len(ranges) == 1 {
+		stream, err := repository.GetPostVideo(ctx, file, ranges[0].start, ranges[0].end)
 		if err != nil {
 			log.Error("Failed to stream video", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to stream video",
 			})
 		}
+		if c.Response().StatusCode() == fiber.StatusPartialContent {
+			c.Set("Content-Range", ranges[0].contentRange(file.FileSize))
+		}
+		c.Set("Content-Type", file.MimeType)
+		return c.SendStream(stream, int(ranges[0].length()))
+	}
+
+	// each part is only streamed once the ones before it are sent, after the
+	// handler returned and its context is done, and with the status already
+	// out, so a part failing to open cuts the body short
+	streamCtx := context.WithoutCancel(ctx)
+	body, length := newMultipartRanges(file.MimeType, file.FileSize, ranges, func(r byteRange) (io.ReadCloser, error) {
+		stream, err := repository.GetPostVideo(streamCtx, file, r.start, r.end)
+		if err != nil {
+			log.Error("Failed to stream video", zap.Error(err))
+		}
+		return stream, err
+	})
+	c.Set("Content-Type", body.ContentType())
+	return c.SendStream(body, int(length))
+}
 
-		return c.SendStream(stream)
+// repositoryFailed answers a request no worker could be borrowed for. Only an
+// exhausted pool is reported as unavailable, as workers failing to access
+// the channel won't get better by retrying.
+func repositoryFailed(c *fiber.Ctx, log *zap.Logger, err error) error {
+	if errors.Is(err, telegram.ErrNoWorkers) {
+		return noWorkerAvailable(c, log, err)
 	}
+	log.Error("failed to access channel", zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to access channel",
+	})
+}
+
+func noWorkerAvailable(c *fiber.Ctx, log *zap.Logger, err error) error {
+	log.Error("no worker available", zap.Error(err))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "No worker available",
+	})
+}
+
+// mediaNotFound answers a request for a message without the media. Links
+// with a hash get the same answer as those with a wrong one, so they can't
+// be used to probe which messages exist.
+func mediaNotFound(c *fiber.Ctx, log *zap.Logger) error {
+	if c.Query("hash") != "" {
+		return invalidMediaLink(c, log, telegram.ErrLinkHashMismatch)
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Media not found",
+	})
+}
+
+func invalidMediaLink(c *fiber.Ctx, log *zap.Logger, err error) error {
+	log.Warn("rejected media link", zap.Error(err))
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "Invalid or expired media link",
+	})
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+// maxRanges is the number of ranges a single request may ask for before the
+// Range header is ignored and the whole file is sent instead.
+const maxRanges = 16
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRange parses a Range header as described in RFC 7233 against a
+// representation of the given size. Headers that are missing, malformed or
+// use another unit are ignored and return no ranges, so the whole
+// representation is served. errUnsatisfiableRange is returned when the header
+// is valid but none of its ranges overlap the representation. Ranges that
+// overlap or are adjacent are merged, and the rest sorted by offset.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, specs, found := strings.Cut(header, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	var parsed int
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parsed++
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range: the last N bytes
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, nil
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-suffix, 0), end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: min(end, size-1)}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if parsed == 0 || len(ranges) > maxRanges {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges sorts ranges by offset and merges those that overlap or are
+// adjacent, so that no byte is fetched and sent twice.
+func mergeRanges(ranges []byteRange) []byteRange {
+	slices.SortFunc(ranges, func(a, b byteRange) int {
+		return int(min(max(a.start-b.start, -1), 1))
+	})
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end+1 {
+			last.end = max(last.end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// fileETag returns a strong entity tag for the file.
+func fileETag(file *models.File) string {
+	return `"` + file.Hash() + `"`
+}
+
+// multipartRanges streams several ranges of a file as a multipart/byteranges
+// body, reading each part from its own reader in turn. The reader of a part
+// is only opened once the part is reached.
+type multipartRanges struct {
+	boundary string
+	parts    []*lazyPart
+	pending  io.Reader
+}
+
+func newMultipartRanges(mimeType string, size int64, ranges []byteRange, open func(r byteRange) (io.ReadCloser, error)) (*multipartRanges, int64) {
+	m := &multipartRanges{
+		boundary: randomBoundary(),
+		parts:    make([]*lazyPart, 0, len(ranges)),
+	}
+
+	var length int64
+	readers := make([]io.Reader, 0, len(ranges)*2+1)
+	for _, r := range ranges {
+		header := m.partHeader(mimeType, r.contentRange(size))
+		length += int64(len(header)) + r.length()
+		part := &lazyPart{open: func() (io.ReadCloser, error) { return open(r) }}
+		m.parts = append(m.parts, part)
+		readers = append(readers, strings.NewReader(header), part)
+	}
+	trailer := "\r\n--" + m.boundary + "--\r\n"
+	length += int64(len(trailer))
+	readers = append(readers, strings.NewReader(trailer))
+
+	m.pending = io.MultiReader(readers...)
+	return m, length
+}
+
+func (m *multipartRanges) partHeader(mimeType, contentRange string) string {
+	return fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", m.boundary, mimeType, contentRange)
+}
+
+func (m *multipartRanges) ContentType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+func (m *multipartRanges) Read(p []byte) (int, error) {
+	return m.pending.Read(p)
+}
+
+func (m *multipartRanges) Close() error {
+	var err error
+	for _, part := range m.parts {
+		if closeErr := part.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// lazyPart opens the reader of a part on its first read and closes it once
+// drained, so that only the part being sent holds a stream open.
+type lazyPart struct {
+	open   func() (io.ReadCloser, error)
+	reader io.ReadCloser
+	done   bool
+}
+
+func (p *lazyPart) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+	if p.reader == nil {
+		reader, err := p.open()
+		if err != nil {
+			return 0, err
+		}
+		p.reader = reader
+	}
+
+	n, err := p.reader.Read(b)
+	if err == io.EOF {
+		p.done = true
+		if closeErr := p.Close(); closeErr != nil {
+			return n, closeErr
+		}
+	}
+	return n, err
+}
+
+func (p *lazyPart) Close() error {
+	if p.reader == nil {
+		return nil
+	}
+	err := p.reader.Close()
+	p.reader = nil
+	return err
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tooMany := make([]string, maxRanges+1)
+	most := make([]byteRange, maxRanges)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%d-%d", i*10, i*10+1)
+		if i < maxRanges {
+			most[i] = byteRange{start: int64(i * 10), end: int64(i*10 + 1)}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		want   []byteRange
+		err    error
+	}{
+		{name: "no header", header: "", size: 1000},
+		{name: "closed range", header: "bytes=0-499", size: 1000, want: []byteRange{{0, 499}}},
+		{name: "single byte", header: "bytes=0-0", size: 1000, want: []byteRange{{0, 0}}},
+		{name: "end past size", header: "bytes=500-5000", size: 1000, want: []byteRange{{500, 999}}},
+		{name: "spaces", header: "bytes = 0-1 , 5-6", size: 1000, want: []byteRange{{0, 1}, {5, 6}}},
+
+		{name: "open ended", header: "bytes=500-", size: 1000, want: []byteRange{{500, 999}}},
+		{name: "open ended from start", header: "bytes=0-", size: 1000, want: []byteRange{{0, 999}}},
+		{name: "open ended last byte", header: "bytes=999-", size: 1000, want: []byteRange{{999, 999}}},
+
+		{name: "suffix", header: "bytes=-200", size: 1000, want: []byteRange{{800, 999}}},
+		{name: "suffix past start", header: "bytes=-2000", size: 1000, want: []byteRange{{0, 999}}},
+		{name: "suffix and first byte", header: "bytes=0-0,-1", size: 1000, want: []byteRange{{0, 0}, {999, 999}}},
+
+		{name: "start past size", header: "bytes=1000-", size: 1000, err: errUnsatisfiableRange},
+		{name: "all past size", header: "bytes=1000-2000,2000-", size: 1000, err: errUnsatisfiableRange},
+		{name: "empty suffix", header: "bytes=-0", size: 1000, err: errUnsatisfiableRange},
+		{name: "empty file", header: "bytes=0-", size: 0, err: errUnsatisfiableRange},
+		{name: "suffix of empty file", header: "bytes=-5", size: 0, err: errUnsatisfiableRange},
+		{name: "unsatisfiable ones dropped", header: "bytes=2000-,0-9", size: 1000, want: []byteRange{{0, 9}}},
+
+		{name: "overlapping", header: "bytes=0-99,50-149", size: 1000, want: []byteRange{{0, 149}}},
+		{name: "adjacent", header: "bytes=0-99,100-199", size: 1000, want: []byteRange{{0, 199}}},
+		{name: "contained", header: "bytes=0-999,10-20", size: 1000, want: []byteRange{{0, 999}}},
+		{name: "out of order", header: "bytes=500-599,0-99", size: 1000, want: []byteRange{{0, 99}, {500, 599}}},
+		{name: "suffix overlapping", header: "bytes=900-949,-100", size: 1000, want: []byteRange{{900, 999}}},
+		{name: "one byte apart", header: "bytes=0-99,101-199", size: 1000, want: []byteRange{{0, 99}, {101, 199}}},
+
+		{name: "most ranges", header: "bytes=" + strings.Join(tooMany[:maxRanges], ","), size: 1000, want: most},
+		{name: "too many ranges", header: "bytes=" + strings.Join(tooMany, ","), size: 1000},
+
+		{name: "other unit", header: "items=0-1", size: 1000},
+		{name: "no unit", header: "0-1", size: 1000},
+		{name: "no ranges", header: "bytes=", size: 1000},
+		{name: "only commas", header: "bytes=,,", size: 1000},
+		{name: "no dash", header: "bytes=5", size: 1000},
+		{name: "not a number", header: "bytes=abc-", size: 1000},
+		{name: "end not a number", header: "bytes=0-abc", size: 1000},
+		{name: "end before start", header: "bytes=5-1", size: 1000},
+		{name: "negative suffix", header: "bytes=--1", size: 1000},
+		{name: "suffix not a number", header: "bytes=-x", size: 1000},
+		{name: "one malformed", header: "bytes=0-1,x", size: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRange(%q) error = %v, want %v", tt.header, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// testPart is the reader of a range of a test file, recording whether it
+// was closed.
+type testPart struct {
+	io.Reader
+	closed bool
+}
+
+func (p *testPart) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestMultipartRanges(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	ranges := []byteRange{{0, 1}, {5, 9}, {18, 19}}
+
+	var opened []*testPart
+	open := func(r byteRange) (io.ReadCloser, error) {
+		// the parts before have been sent and closed by now
+		for _, part := range opened {
+			if !part.closed {
+				t.Errorf("part opened while a previous one is still open")
+			}
+		}
+		part := &testPart{Reader: bytes.NewReader(content[r.start : r.end+1])}
+		opened = append(opened, part)
+		return part, nil
+	}
+
+	body, length := newMultipartRanges("video/mp4", int64(len(content)), ranges, open)
+	if len(opened) != 0 {
+		t.Fatalf("%d parts opened before the body was read", len(opened))
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if int64(len(data)) != length {
+		t.Errorf("body is %d bytes, announced %d", len(data), length)
+	}
+	if len(opened) != len(ranges) {
+		t.Fatalf("%d parts opened, want %d", len(opened), len(ranges))
+	}
+	for i, part := range opened {
+		if !part.closed {
+			t.Errorf("part %d was not closed", i)
+		}
+	}
+
+	_, params, err := mime.ParseMediaType(body.ContentType())
+	if err != nil {
+		t.Fatalf("invalid content type %q: %v", body.ContentType(), err)
+	}
+	reader := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+	for i, r := range ranges {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("part %d Content-Type = %q", i, got)
+		}
+		if got, want := part.Header.Get("Content-Range"), r.contentRange(int64(len(content))); got != want {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, want)
+		}
+		got, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if want := content[r.start : r.end+1]; !bytes.Equal(got, want) {
+			t.Errorf("part %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected no more parts, got %v", err)
+	}
+}
+
+func TestMultipartRangesClose(t *testing.T) {
+	var opened []*testPart
+	open := func(r byteRange) (io.ReadCloser, error) {
+		if r.start == 10 {
+			return nil, errors.New("no worker available")
+		}
+		part := &testPart{Reader: strings.NewReader("xx")}
+		opened = append(opened, part)
+		return part, nil
+	}
+
+	body, _ := newMultipartRanges("video/mp4", 20, []byteRange{{0, 1}, {10, 11}}, open)
+
+	// the first part is read and closed, then the second fails to open
+	if _, err := io.ReadAll(body); err == nil || !strings.Contains(err.Error(), "no worker available") {
+		t.Fatalf("ReadAll() error = %v, want the error opening the second part", err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(opened) != 1 || !opened[0].closed {
+		t.Errorf("opened %d parts, want the first one closed", len(opened))
+	}
+
+	// closing a body part way through closes the part being read
+	var partial []*testPart
+	body, _ = newMultipartRanges("video/mp4", 20, []byteRange{{0, 1}, {10, 11}}, func(r byteRange) (io.ReadCloser, error) {
+		part := &testPart{Reader: strings.NewReader("xx")}
+		partial = append(partial, part)
+		return part, nil
+	})
+	buf := make([]byte, 1)
+	for len(partial) == 0 {
+		if _, err := body.Read(buf); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(partial) != 1 || !partial[0].closed {
+		t.Errorf("opened %d parts, want the first one closed", len(partial))
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"go-winx-api/internal/models"
+	"go-winx-api/internal/services/telegram"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func SearchPosts(log *zap.Logger) fiber.Handler {
+	log = log.Named("search")
+
+	return func(c *fiber.Ctx) error {
+		channels, err := listingChannels(c)
+		if err != nil {
+			return channelUnavailable(c, err)
+		}
+
+		pagination, err := parsePagination(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		filters, err := parseFilters(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		if pagination.Search = strings.TrimSpace(c.Query("q")); pagination.Search == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Missing 'q' parameter",
+			})
+		}
+
+		if pagination.Sort, err = parseSort(c, pagination.Search); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return searchPosts(c, log, channels, pagination, filters)
+	}
+}
+
+func searchPosts(c *fiber.Ctx, log *zap.Logger, channels []*models.Channel, pagination models.PaginationData, filters models.PostFilters) error {
+	log.Info("Searching posts", zap.String("search", pagination.Search), zap.Int("per_page", pagination.PerPage), zap.String("sort", pagination.Sort))
+
+	posts, err := telegram.SearchPosts(c.UserContext(), log, channels, pagination, filters)
+	if errors.Is(err, telegram.ErrNoWorkers) {
+		return noWorkerAvailable(c, log, err)
+	}
+	if errors.Is(err, telegram.ErrFiltersUnavailable) {
+		return filtersUnavailable(c, log, err)
+	}
+	if errors.Is(err, telegram.ErrIndexUnavailable) {
+		return indexUnavailable(c, log, err)
+	}
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return invalidCursor(c)
+	}
+	if err != nil {
+		log.Error("Failed to search posts", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search posts",
+		})
+	}
+
+	setPageLinks(c, posts.Pagination)
+	return c.JSON(posts)
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"go-winx-api/internal/services/telegram"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func GetWorkersStatus(log *zap.Logger) fiber.Handler {
+	log = log.Named("workers")
+
+	return func(c *fiber.Ctx) error {
+		log.Debug("Fetching workers status")
+		return c.JSON(telegram.Workers.Status())
+	}
+}
@@ -1,6 +1,10 @@
 package middleware
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -21,3 +25,41 @@ func RequestLogger(log *zap.Logger) fiber.Handler {
 		return err
 	}
 }
+
+func AdminAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provided := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequestContext gives every request a context that is cancelled once the
+// handler returns or after timeout, whichever comes first. Handlers read it
+// with c.UserContext(). A timeout of 0 disables the deadline.
+func RequestContext(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(c.UserContext(), timeout)
+		} else {
+			ctx, cancel = context.WithCancel(c.UserContext())
+		}
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && c.Response().StatusCode() >= fiber.StatusInternalServerError {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+				"error": "Request timed out",
+			})
+		}
+		return err
+	}
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go-winx-api/config"
+	"go-winx-api/internal/server/http/handlers"
+	"go-winx-api/internal/server/http/middleware"
+	"go.uber.org/zap"
+)
+
+func registerAdminRoutes(app *fiber.App, log *zap.Logger) {
+	if config.ValueOf.AdminToken == "" {
+		log.Sugar().Info("ADMIN_TOKEN not set, admin routes disabled")
+		return
+	}
+
+	admin := app.Group("/api/v1/admin", middleware.AdminAuth(config.ValueOf.AdminToken))
+
+	admin.Get("/workers", handlers.GetWorkersStatus(log))
+	admin.Get("/cache/chunks", handlers.GetChunkCacheStats(log))
+}
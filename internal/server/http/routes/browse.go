@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go-winx-api/internal/server/http/handlers"
+	"go.uber.org/zap"
+)
+
+func registerBrowseRoutes(app *fiber.App, log *zap.Logger) {
+
+	api := app.Group("/api/v1")
+
+	api.Get("/people", handlers.GetPeople(log))
+	api.Get("/people/:name/posts", handlers.GetPersonPosts(log))
+	api.Get("/genres", handlers.GetGenres(log))
+	api.Get("/countries", handlers.GetCountries(log))
+	api.Get("/tags", handlers.GetTags(log))
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go-winx-api/internal/server/http/handlers"
+	"go.uber.org/zap"
+)
+
+func registerChannelRoutes(app *fiber.App, log *zap.Logger) {
+
+	api := app.Group("/api/v1")
+
+	api.Get("/channels", handlers.GetChannels(log))
+
+	channel := api.Group("/channels/:slug")
+
+	channel.Get("/posts", handlers.GetAllPosts(log))
+	channel.Get("/posts/:message_id", handlers.GetPost(log))
+	channel.Get("/posts/images/:message_id", handlers.GetPostImage(log))
+	channel.Get("/posts/videos/:message_id", handlers.GetPostVideo(log))
+	channel.Get("/posts/downloads/:message_id", handlers.GetPostDownload(log))
+	channel.Get("/search", handlers.SearchPosts(log))
+}
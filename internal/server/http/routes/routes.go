@@ -15,4 +15,7 @@ func SetupRoutes(app *fiber.App, log *zap.Logger) {
 	})
 
 	registerPostRoutes(app, log)
+	registerBrowseRoutes(app, log)
+	registerChannelRoutes(app, log)
+	registerAdminRoutes(app, log)
 }
@@ -19,10 +19,12 @@ func NewServer(log *zap.Logger) *Server {
 	app := fiber.New()
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, X-Channel-Token, Range, If-Range, If-None-Match",
+		ExposeHeaders: "Link, Content-Range, Accept-Ranges, ETag, Last-Modified, Content-Disposition",
 	}))
 	app.Use(middleware.RequestLogger(log))
+	app.Use(middleware.RequestContext(config.ValueOf.RequestTimeout))
 
 	app.Static("/", "./docs")
 
@@ -0,0 +1,143 @@
+package telegram
+
+import (
+	"strings"
+
+	"go-winx-api/config"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// AssemblyRule is a way the messages of the channel are put together into
+// a post, enabled with POST_ASSEMBLY.
+type AssemblyRule string
+
+const (
+	// AssembleAlbums makes a post of an album with a caption, with all the
+	// photos and documents in it.
+	AssembleAlbums AssemblyRule = "albums"
+	// AssembleStandalone makes a post of a photo or document sent alone
+	// with a caption.
+	AssembleStandalone AssemblyRule = "standalone"
+	// AssembleSequences makes a post of a caption followed by documents
+	// sent without one.
+	AssembleSequences AssemblyRule = "sequences"
+)
+
+var assemblyRules = map[AssemblyRule]bool{
+	AssembleAlbums:     true,
+	AssembleStandalone: true,
+	AssembleSequences:  true,
+}
+
+// InitAssembly enables the rules listed in POST_ASSEMBLY, or all of them
+// when it lists none.
+func InitAssembly(log *zap.Logger) {
+	log = log.Named("assembly")
+
+	rules := make(map[AssemblyRule]bool)
+	var enabled []string
+	for _, value := range config.ValueOf.PostAssembly {
+		switch rule := AssemblyRule(strings.TrimSpace(value)); rule {
+		case AssembleAlbums, AssembleStandalone, AssembleSequences:
+			if !rules[rule] {
+				rules[rule] = true
+				enabled = append(enabled, string(rule))
+			}
+		case "":
+		default:
+			log.Sugar().Warnf("unknown POST_ASSEMBLY rule %q, ignoring it", rule)
+		}
+	}
+
+	if len(rules) == 0 {
+		log.Sugar().Info("no POST_ASSEMBLY rule set, enabling all of them")
+		return
+	}
+	assemblyRules = rules
+	log.Sugar().Infof("assembling posts from %s", strings.Join(enabled, ", "))
+}
+
+// assemblePosts puts messages that follow each other in the channel, oldest
+// first, together into posts, and returns the messages of each, oldest
+// first. Messages that aren't part of a post are left out, as are albums
+// without a caption.
+func assemblePosts(messages []*tg.Message) [][]*tg.Message {
+	var posts [][]*tg.Message
+	albums := make(map[int64]int)
+
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+
+		if msg.GroupedID != 0 {
+			if !assemblyRules[AssembleAlbums] {
+				continue
+			}
+			if j, ok := albums[msg.GroupedID]; ok {
+				posts[j] = append(posts[j], msg)
+			} else {
+				albums[msg.GroupedID] = len(posts)
+				posts = append(posts, []*tg.Message{msg})
+			}
+			continue
+		}
+
+		if msg.Message == "" {
+			continue
+		}
+
+		post := []*tg.Message{msg}
+		if assemblyRules[AssembleSequences] && !hasDocument(msg) {
+			for i+1 < len(messages) && len(post) < maxAlbumSize && isBareDocument(messages[i+1]) {
+				i++
+				post = append(post, messages[i])
+			}
+		}
+		if len(post) > 1 || (assemblyRules[AssembleStandalone] && hasMedia(msg)) {
+			posts = append(posts, post)
+		}
+	}
+
+	captioned := posts[:0]
+	for _, post := range posts {
+		for _, msg := range post {
+			if msg.Message != "" {
+				captioned = append(captioned, post)
+				break
+			}
+		}
+	}
+	return captioned
+}
+
+// postContaining returns the post of posts that messageID is part of.
+func postContaining(posts [][]*tg.Message, messageID int) []*tg.Message {
+	for _, post := range posts {
+		for _, msg := range post {
+			if msg.ID == messageID {
+				return post
+			}
+		}
+	}
+	return nil
+}
+
+func hasMedia(msg *tg.Message) bool {
+	switch msg.Media.(type) {
+	case *tg.MessageMediaPhoto, *tg.MessageMediaDocument:
+		return true
+	}
+	return false
+}
+
+func hasDocument(msg *tg.Message) bool {
+	_, ok := msg.Media.(*tg.MessageMediaDocument)
+	return ok
+}
+
+// isBareDocument reports whether msg is a document sent alone without a
+// caption, which may belong to the caption before it.
+func isBareDocument(msg *tg.Message) bool {
+	return msg.GroupedID == 0 && msg.Message == "" && hasDocument(msg)
+}
@@ -0,0 +1,297 @@
+package telegram
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+// Kinds of test messages, by what they were sent with.
+const (
+	msgText = iota
+	msgDocument
+	msgPhoto
+)
+
+// testMessage is a message of a test channel, with a caption when caption
+// is set and part of album when it isn't 0.
+func testMessage(id, kind int, caption bool, album int64) *tg.Message {
+	msg := &tg.Message{ID: id, GroupedID: album}
+	if caption {
+		msg.Message = "caption"
+	}
+	switch kind {
+	case msgDocument:
+		msg.Media = &tg.MessageMediaDocument{Document: &tg.Document{ID: int64(id)}}
+	case msgPhoto:
+		msg.Media = &tg.MessageMediaPhoto{Photo: &tg.Photo{ID: int64(id)}}
+	}
+	return msg
+}
+
+// bareDocuments returns documents from to to sent without a caption.
+func bareDocuments(from, to int) []*tg.Message {
+	var messages []*tg.Message
+	for id := from; id <= to; id++ {
+		messages = append(messages, testMessage(id, msgDocument, false, 0))
+	}
+	return messages
+}
+
+func concat(groups ...[]*tg.Message) []*tg.Message {
+	var messages []*tg.Message
+	for _, group := range groups {
+		messages = append(messages, group...)
+	}
+	return messages
+}
+
+func one(msg *tg.Message) []*tg.Message {
+	return []*tg.Message{msg}
+}
+
+// postIDs returns the IDs of the messages of each post.
+func postIDs(posts [][]*tg.Message) [][]int {
+	ids := [][]int{}
+	for _, post := range posts {
+		var postIDs []int
+		for _, msg := range post {
+			postIDs = append(postIDs, msg.ID)
+		}
+		ids = append(ids, postIDs)
+	}
+	return ids
+}
+
+func withAssemblyRules(t *testing.T, rules ...AssemblyRule) {
+	t.Helper()
+	previous := assemblyRules
+	t.Cleanup(func() { assemblyRules = previous })
+
+	assemblyRules = make(map[AssemblyRule]bool)
+	for _, rule := range rules {
+		assemblyRules[rule] = true
+	}
+}
+
+func TestAssemblePosts(t *testing.T) {
+	all := []AssemblyRule{AssembleAlbums, AssembleStandalone, AssembleSequences}
+
+	tests := []struct {
+		name     string
+		rules    []AssemblyRule
+		messages []*tg.Message
+		want     [][]int
+	}{
+		{name: "no messages", rules: all, want: [][]int{}},
+		{
+			name:  "album captioned on first message",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgPhoto, true, 100),
+				testMessage(2, msgDocument, false, 100),
+				testMessage(3, msgDocument, false, 100),
+			},
+			want: [][]int{{1, 2, 3}},
+		},
+		{
+			name:  "album captioned on last message",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgDocument, false, 100),
+				testMessage(2, msgDocument, true, 100),
+			},
+			want: [][]int{{1, 2}},
+		},
+		{
+			name:  "album without caption",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgDocument, false, 100),
+				testMessage(2, msgDocument, false, 100),
+			},
+			want: [][]int{},
+		},
+		{
+			name:  "adjacent albums",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgPhoto, true, 100),
+				testMessage(2, msgDocument, false, 100),
+				testMessage(3, msgPhoto, true, 200),
+				testMessage(4, msgDocument, false, 200),
+			},
+			want: [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			name:  "interleaved albums",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgPhoto, true, 100),
+				testMessage(2, msgPhoto, true, 200),
+				testMessage(3, msgDocument, false, 100),
+				testMessage(4, msgDocument, false, 200),
+			},
+			want: [][]int{{1, 3}, {2, 4}},
+		},
+		{
+			name:     "caption followed by documents",
+			rules:    all,
+			messages: concat(one(testMessage(1, msgText, true, 0)), bareDocuments(2, 4)),
+			want:     [][]int{{1, 2, 3, 4}},
+		},
+		{
+			name:     "photo followed by documents",
+			rules:    all,
+			messages: concat(one(testMessage(1, msgPhoto, true, 0)), bareDocuments(2, 3)),
+			want:     [][]int{{1, 2, 3}},
+		},
+		{
+			name:  "sequence ends at the next caption",
+			rules: all,
+			messages: concat(
+				one(testMessage(1, msgText, true, 0)), bareDocuments(2, 3),
+				one(testMessage(4, msgText, true, 0)), bareDocuments(5, 5),
+			),
+			want: [][]int{{1, 2, 3}, {4, 5}},
+		},
+		{
+			name:  "sequence ends at an album",
+			rules: all,
+			messages: concat(
+				one(testMessage(1, msgText, true, 0)), bareDocuments(2, 2),
+				one(testMessage(3, msgDocument, true, 100)), one(testMessage(4, msgDocument, false, 100)),
+			),
+			want: [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			name:  "sequence ends at a captioned document",
+			rules: all,
+			messages: concat(
+				one(testMessage(1, msgText, true, 0)), bareDocuments(2, 2),
+				one(testMessage(3, msgDocument, true, 0)), bareDocuments(4, 4),
+			),
+			want: [][]int{{1, 2}, {3}},
+		},
+		{
+			name:     "sequence capped at album size",
+			rules:    all,
+			messages: concat(one(testMessage(1, msgText, true, 0)), bareDocuments(2, 12)),
+			want:     [][]int{{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		},
+		{
+			name:     "documents without a caption before them",
+			rules:    all,
+			messages: concat(bareDocuments(1, 2), one(testMessage(3, msgText, true, 0))),
+			want:     [][]int{},
+		},
+		{
+			name:  "standalone media",
+			rules: all,
+			messages: []*tg.Message{
+				testMessage(1, msgDocument, true, 0),
+				testMessage(2, msgPhoto, true, 0),
+				testMessage(3, msgText, true, 0),
+			},
+			want: [][]int{{1}, {2}},
+		},
+		{
+			name:  "only albums",
+			rules: []AssemblyRule{AssembleAlbums},
+			messages: concat(
+				one(testMessage(1, msgDocument, true, 0)),
+				one(testMessage(2, msgText, true, 0)), bareDocuments(3, 3),
+				one(testMessage(4, msgPhoto, true, 100)), one(testMessage(5, msgDocument, false, 100)),
+			),
+			want: [][]int{{4, 5}},
+		},
+		{
+			name:  "without albums",
+			rules: []AssemblyRule{AssembleStandalone, AssembleSequences},
+			messages: concat(
+				one(testMessage(1, msgPhoto, true, 100)), one(testMessage(2, msgDocument, false, 100)),
+				one(testMessage(3, msgDocument, true, 0)),
+			),
+			want: [][]int{{3}},
+		},
+		{
+			name:  "sequences without standalone",
+			rules: []AssemblyRule{AssembleSequences},
+			messages: concat(
+				one(testMessage(1, msgDocument, true, 0)),
+				one(testMessage(2, msgPhoto, true, 0)),
+				one(testMessage(3, msgText, true, 0)), bareDocuments(4, 5),
+			),
+			want: [][]int{{3, 4, 5}},
+		},
+		{
+			name:     "standalone without sequences",
+			rules:    []AssemblyRule{AssembleStandalone},
+			messages: concat(one(testMessage(1, msgText, true, 0)), bareDocuments(2, 3), one(testMessage(4, msgPhoto, true, 0))),
+			want:     [][]int{{4}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAssemblyRules(t, tt.rules...)
+			if got := postIDs(assemblePosts(tt.messages)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assemblePosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteGroups(t *testing.T) {
+	withAssemblyRules(t, AssembleAlbums, AssembleStandalone, AssembleSequences)
+
+	// a page of the history, newest first, as it comes from Telegram
+	page := []*tg.Message{
+		testMessage(9, msgDocument, false, 0),
+		testMessage(8, msgText, true, 0),
+		testMessage(7, msgDocument, false, 200),
+		testMessage(6, msgPhoto, true, 200),
+		testMessage(5, msgDocument, true, 0),
+		testMessage(4, msgDocument, false, 100),
+		testMessage(3, msgDocument, true, 100),
+	}
+
+	tests := []struct {
+		name      string
+		oldest    int
+		exhausted bool
+		want      [][]int
+	}{
+		{name: "album at the page edge", oldest: 3, want: [][]int{{8, 9}, {6, 7}, {5}}},
+		{name: "start of the history", oldest: 3, exhausted: true, want: [][]int{{8, 9}, {6, 7}, {5}, {3, 4}}},
+		{name: "older message seen", oldest: 2, want: [][]int{{8, 9}, {6, 7}, {5}, {3, 4}}},
+		{name: "only the newest post", oldest: 7, want: [][]int{{8, 9}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := postIDs(completeGroups(page, tt.oldest, tt.exhausted))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("completeGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostContaining(t *testing.T) {
+	posts := [][]*tg.Message{
+		concat(one(testMessage(1, msgText, true, 0)), bareDocuments(2, 3)),
+		one(testMessage(5, msgDocument, true, 0)),
+	}
+
+	for id, want := range map[int][]int{1: {1, 2, 3}, 3: {1, 2, 3}, 5: {5}, 4: nil} {
+		var got []int
+		for _, msg := range postContaining(posts, id) {
+			got = append(got, msg.ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("postContaining(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
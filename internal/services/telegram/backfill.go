@@ -0,0 +1,221 @@
+package telegram
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/index"
+	"go-winx-api/internal/models"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+const (
+	backfillPageSize  = 100
+	backfillPageDelay = time.Second
+)
+
+// StartBackfill indexes the whole history of every channel in the
+// background, one after the other, then catches up with newer posts every
+// BACKFILL_INTERVAL. An interrupted backfill resumes from the last page it
+// indexed.
+func StartBackfill(ctx context.Context, log *zap.Logger) {
+	log = log.Named("backfill")
+
+	idx := index.GetIndex()
+	if idx == nil {
+		return
+	}
+
+	go func() {
+		for {
+			for _, channel := range Channels() {
+				log := log.With(zap.String("channel", channel.Slug))
+				if err := backfill(ctx, log, idx, channel); err != nil {
+					log.Error("backfill failed", zap.Error(err))
+				}
+			}
+
+			interval := config.ValueOf.BackfillInterval
+			if interval <= 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func backfill(ctx context.Context, log *zap.Logger, idx *index.Index, channel *models.Channel) error {
+	state, err := idx.BackfillState(ctx, channel.ID)
+	if err != nil {
+		return err
+	}
+
+	newest, err := idx.MaxMessageID(ctx, channel.ID)
+	if err != nil {
+		return err
+	}
+	if newest > 0 {
+		log.Sugar().Infof("indexing posts newer than message %d", newest)
+		if err := walkHistory(ctx, log, idx, channel, 0, newest, nil); err != nil {
+			return err
+		}
+	}
+
+	if state.Done {
+		return nil
+	}
+
+	log.Sugar().Infof("indexing channel history from offset %d", state.OffsetID)
+	err = walkHistory(ctx, log, idx, channel, state.OffsetID, 0, func(offsetID int) error {
+		state.OffsetID = offsetID
+		return idx.SaveBackfillState(ctx, channel.ID, state)
+	})
+	if err != nil {
+		return err
+	}
+
+	state.Done = true
+	if err := idx.SaveBackfillState(ctx, channel.ID, state); err != nil {
+		return err
+	}
+	log.Info("channel history indexed")
+	return nil
+}
+
+// walkHistory indexes the posts of channel older than offsetID and newer
+// than minID, newest first. Posts that straddle two pages are held back until the next
+// page completes them, and progress is called with the offset to resume
+// from after every page.
+func walkHistory(ctx context.Context, log *zap.Logger, idx *index.Index, channel *models.Channel, offsetID, minID int, progress func(offsetID int) error) error {
+	var held []*tg.Message
+	for {
+		messages, err := historyPage(ctx, log, channel, offsetID)
+		if err != nil {
+			return err
+		}
+
+		finished := len(messages) == 0
+		for _, msg := range messages {
+			if offsetID == 0 || msg.ID < offsetID {
+				offsetID = msg.ID
+			}
+		}
+		held = append(held, messages...)
+		sort.Slice(held, func(i, j int) bool {
+			return held[i].ID > held[j].ID
+		})
+
+		if minID > 0 {
+			var reached bool
+			held, reached = keepNewerThan(held, minID)
+			finished = finished || reached
+		}
+
+		var pending []*tg.Message
+		if !finished && len(held) > 0 {
+			held, pending = splitPending(held)
+		}
+
+		if err := idx.UpsertPosts(ctx, postsFromMessages(channel, held)); err != nil {
+			return err
+		}
+		if progress != nil && len(held) > 0 {
+			if err := progress(held[len(held)-1].ID); err != nil {
+				return err
+			}
+		}
+		log.Debug("indexed history page", zap.Int("messages", len(held)), zap.Int("offset_id", offsetID))
+
+		if finished {
+			return nil
+		}
+		held = pending
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backfillPageDelay):
+		}
+	}
+}
+
+func historyPage(ctx context.Context, log *zap.Logger, channel *models.Channel, offsetID int) ([]*tg.Message, error) {
+	repository, err := NewChannelRepository(ctx, log, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	return repository.GetHistory(ctx, backfillPageSize, offsetID)
+}
+
+// keepNewerThan drops the messages at or below minID, except those that
+// belong to an album with a newer message. It reports whether any message
+// was dropped.
+func keepNewerThan(messages []*tg.Message, minID int) ([]*tg.Message, bool) {
+	groups := make(map[int64]bool)
+	for _, msg := range messages {
+		if msg.ID > minID && msg.GroupedID != 0 {
+			groups[msg.GroupedID] = true
+		}
+	}
+
+	var kept []*tg.Message
+	var reached bool
+	for _, msg := range messages {
+		if msg.ID > minID || groups[msg.GroupedID] {
+			kept = append(kept, msg)
+		} else {
+			reached = true
+		}
+	}
+	return kept, reached
+}
+
+// splitPending separates, from messages sorted newest first, the oldest
+// ones that may still make a post with messages of the next page. A post is
+// put together from at most maxAlbumSize messages, so only those that
+// aren't part of a complete post among the oldest maxAlbumSize are held.
+func splitPending(messages []*tg.Message) ([]*tg.Message, []*tg.Message) {
+	oldest := messages[len(messages)-1].ID
+	boundary := 0
+	for _, post := range completeGroups(messages, oldest, false) {
+		boundary = post[0].ID
+	}
+
+	tail := max(len(messages)-maxAlbumSize, 0)
+	complete, pending := messages[:tail:tail], []*tg.Message(nil)
+	for _, msg := range messages[tail:] {
+		if boundary == 0 || msg.ID < boundary {
+			pending = append(pending, msg)
+		} else {
+			complete = append(complete, msg)
+		}
+	}
+	return complete, pending
+}
+
+// postsFromMessages builds every post of channel put together from messages.
+func postsFromMessages(channel *models.Channel, messages []*tg.Message) []models.Post {
+	sorted := slices.Clone(messages)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var posts []models.Post
+	for _, group := range assemblePosts(sorted) {
+		if post := createPostFromMessages(channel, group); post != nil {
+			posts = append(posts, *post)
+		}
+	}
+	return posts
+}
@@ -0,0 +1,277 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"go-winx-api/internal/index"
+	"go-winx-api/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// ErrFiltersUnavailable is returned when posts are filtered or sorted before
+// the local index has been built, since only the index can do either.
+var ErrFiltersUnavailable = errors.New("filters and sorting need the local index")
+
+// ErrIndexUnavailable is returned by listings that are only served from the
+// local index while it's disabled or still being built.
+var ErrIndexUnavailable = errors.New("local index is not ready")
+
+// PaginatePosts lists the posts of channels from the local index once their
+// history has been backfilled. Before that the history of a single channel
+// is walked through a worker, and listings across channels fall back to the
+// default channel.
+func PaginatePosts(ctx context.Context, logger *zap.Logger, channels []*models.Channel, pagination models.PaginationData, filters models.PostFilters) (*models.PaginatedPosts, error) {
+	if idx := index.GetIndex(); idx.Ready(channelIDs(channels)...) {
+		return paginateIndexed(ctx, idx, channels, pagination, filters)
+	}
+	if !filters.IsEmpty() || postSort(pagination) != index.SortNewest {
+		return nil, ErrFiltersUnavailable
+	}
+	channel := walkedChannel(channels)
+	if channel == nil {
+		return nil, ErrIndexUnavailable
+	}
+
+	repository, err := NewChannelRepository(ctx, logger, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	return repository.PaginatePosts(ctx, pagination)
+}
+
+// SearchPosts searches the posts of channels in the local index once their
+// history has been backfilled, and a single channel, or the default one of
+// several, itself through a worker before.
+func SearchPosts(ctx context.Context, logger *zap.Logger, channels []*models.Channel, pagination models.PaginationData, filters models.PostFilters) (*models.PaginatedPosts, error) {
+	if idx := index.GetIndex(); idx.Ready(channelIDs(channels)...) {
+		return paginateIndexed(ctx, idx, channels, pagination, filters)
+	}
+	// Telegram returns the posts found newest first, which is as relevant
+	// as it gets without the index
+	if sort := postSort(pagination); !filters.IsEmpty() || (sort != index.SortRelevance && sort != index.SortNewest) {
+		return nil, ErrFiltersUnavailable
+	}
+	channel := walkedChannel(channels)
+	if channel == nil {
+		return nil, ErrIndexUnavailable
+	}
+
+	repository, err := NewChannelRepository(ctx, logger, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	return repository.SearchPosts(ctx, pagination)
+}
+
+// ListTerms lists the values of the given index fields across the posts of
+// channels, with the number of posts that have each.
+func ListTerms(ctx context.Context, channels []*models.Channel, fields []string, search string, pagination models.PaginationData) (*models.PaginatedTerms, error) {
+	idx := index.GetIndex()
+	if !idx.Ready(channelIDs(channels)...) {
+		return nil, ErrIndexUnavailable
+	}
+
+	terms, total, err := idx.Terms(ctx, index.TermQuery{
+		Channels: channelIDs(channels),
+		Fields:   fields,
+		Search:   search,
+		Offset:   pagination.AddOffset,
+		Limit:    pagination.PerPage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pagination.Total = total
+	pagination.Search = search
+	return &models.PaginatedTerms{
+		Data:       terms,
+		Pagination: pagination,
+	}, nil
+}
+
+// GetPost returns the post of channel from the local index or the cache,
+// and only borrows a worker to fetch it from the channel when it's in
+// neither.
+func GetPost(ctx context.Context, logger *zap.Logger, channel *models.Channel, messageID int) (*models.Post, error) {
+	if post := indexedPost(ctx, logger, channel.ID, messageID); post != nil {
+		return post, nil
+	}
+	if post, err := cachedPost(logger, channel, messageID); post != nil || err != nil {
+		return post, err
+	}
+
+	repository, err := NewChannelRepository(ctx, logger, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	return repository.fetchPost(ctx, messageID)
+}
+
+// paginateIndexed pages through the index with cursors. Listings are paged
+// from the last post of the previous page, so that posts published in the
+// meantime don't shift pages, and results ranked by relevance by offset.
+func paginateIndexed(ctx context.Context, idx *index.Index, channels []*models.Channel, pagination models.PaginationData, filters models.PostFilters) (*models.PaginatedPosts, error) {
+	pagination.Sort = postSort(pagination)
+	cursor, err := models.ParseCursor(pagination.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Sort != "" && cursor.Sort != pagination.Sort {
+		return nil, models.ErrInvalidCursor
+	}
+
+	query := index.Query{
+		Channels: channelIDs(channels),
+		OffsetID: pagination.OffsetId,
+		Offset:   pagination.AddOffset,
+		Search:   pagination.Search,
+		Sort:     pagination.Sort,
+		Filters:  filters,
+	}
+
+	total, err := idx.CountPosts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	facets, err := idx.Facets(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case pagination.Cursor == "":
+	case query.Sort == index.SortRelevance:
+		query.Offset = cursor.Offset
+	case cursor.ID > 0 && cursor.Key == nil:
+		// cursors made while the history was walked only know the message,
+		// which orders the newest posts of a single channel
+		if cursor.Prev || query.Sort != index.SortNewest || len(channels) != 1 {
+			return nil, models.ErrInvalidCursor
+		}
+		query.OffsetID = cursor.ID
+	case cursor.ID > 0:
+		query.OffsetID = 0
+		query.From = &index.Position{Key: cursor.Key, ChannelID: cursor.Channel, MessageID: cursor.ID, Before: cursor.Prev}
+	}
+	backwards := query.From != nil && query.From.Before
+
+	// one more post than asked tells whether there's a page after this one
+	query.Limit = pagination.PerPage + 1
+	posts, err := idx.ListPosts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	more := len(posts) > pagination.PerPage
+	if more {
+		posts = posts[:pagination.PerPage]
+	}
+	if backwards {
+		slices.Reverse(posts)
+	}
+
+	for i := range posts {
+		setMediaURLs(&posts[i])
+	}
+
+	pagination.Total = total
+	if len(posts) > 0 {
+		pagination.FirstOffsetId = posts[0].MessageID
+		pagination.LastOffsetId = posts[len(posts)-1].MessageID
+	}
+
+	switch {
+	case query.Sort == index.SortRelevance:
+		if more {
+			pagination.NextCursor = models.Cursor{Sort: query.Sort, Offset: query.Offset + len(posts)}.Encode()
+		}
+		if query.Offset > 0 {
+			pagination.PrevCursor = models.Cursor{Sort: query.Sort, Offset: max(0, query.Offset-pagination.PerPage)}.Encode()
+		}
+	case len(posts) > 0:
+		hasNext, hasPrev := more, query.From != nil || query.OffsetID > 0
+		if backwards {
+			hasNext, hasPrev = true, more
+		}
+		if hasNext {
+			last := &posts[len(posts)-1]
+			pagination.NextCursor = models.Cursor{
+				Sort:    query.Sort,
+				Channel: last.ChannelID,
+				ID:      last.MessageID,
+				Key:     index.SortKey(query.Sort, last),
+			}.Encode()
+		}
+		if hasPrev {
+			first := &posts[0]
+			pagination.PrevCursor = models.Cursor{
+				Sort:    query.Sort,
+				Channel: first.ChannelID,
+				ID:      first.MessageID,
+				Key:     index.SortKey(query.Sort, first),
+				Prev:    true,
+			}.Encode()
+		}
+	}
+
+	return &models.PaginatedPosts{
+		Data:       posts,
+		Pagination: pagination,
+		Facets:     facets,
+	}, nil
+}
+
+// walkedChannel returns the channel whose history is walked while the local
+// index isn't ready: the only one of channels, or the default channel among
+// several, since the history of several can't be walked in order. It's nil
+// when the default channel isn't one of them.
+func walkedChannel(channels []*models.Channel) *models.Channel {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+	if i := slices.Index(channels, DefaultChannel()); i >= 0 {
+		return channels[i]
+	}
+	return nil
+}
+
+// postSort returns the order of the posts of pagination, newest first by
+// default and the most relevant first when searching.
+func postSort(pagination models.PaginationData) string {
+	switch {
+	case pagination.Sort != "":
+		return pagination.Sort
+	case pagination.Search != "":
+		return index.SortRelevance
+	default:
+		return index.SortNewest
+	}
+}
+
+func indexedPost(ctx context.Context, logger *zap.Logger, channelID int64, messageID int) *models.Post {
+	idx := index.GetIndex()
+	if idx == nil {
+		return nil
+	}
+
+	post, err := idx.GetPost(ctx, channelID, messageID)
+	if err != nil {
+		if !errors.Is(err, index.ErrNotFound) {
+			logger.Error("failed to read post from index", zap.Error(err))
+		}
+		return nil
+	}
+
+	setMediaURLs(post)
+	return post
+}
@@ -0,0 +1,63 @@
+package telegram
+
+import (
+	"errors"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+)
+
+// ErrChannelNotFound is returned for a slug that isn't configured.
+var ErrChannelNotFound = errors.New("channel not found")
+
+// Channels returns the configured channels, the default one first.
+func Channels() []*models.Channel {
+	channels := make([]*models.Channel, 0, len(config.ValueOf.Channels))
+	for i := range config.ValueOf.Channels {
+		channels = append(channels, &config.ValueOf.Channels[i])
+	}
+	return channels
+}
+
+// DefaultChannel returns the channel served by the routes without a slug,
+// which is CHANNEL_ID or else the first of CHANNELS.
+func DefaultChannel() *models.Channel {
+	return &config.ValueOf.Channels[0]
+}
+
+// ChannelBySlug returns the channel named slug.
+func ChannelBySlug(slug string) (*models.Channel, error) {
+	for _, channel := range Channels() {
+		if channel.Slug == slug {
+			return channel, nil
+		}
+	}
+	return nil, ErrChannelNotFound
+}
+
+// channelByID returns the configured channel with the Telegram id, or nil.
+func channelByID(id int64) *models.Channel {
+	for _, channel := range Channels() {
+		if channel.ID == id {
+			return channel
+		}
+	}
+	return nil
+}
+
+// postChannel returns the channel post was published in. Posts cached
+// before there were several channels belong to the default one.
+func postChannel(post *models.Post) *models.Channel {
+	if channel := channelByID(post.ChannelID); channel != nil {
+		return channel
+	}
+	return DefaultChannel()
+}
+
+func channelIDs(channels []*models.Channel) []int64 {
+	ids := make([]int64, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.ID)
+	}
+	return ids
+}
@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	"go-winx-api/config"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// lookups coalesces identical Telegram lookups in flight, so that the
+// requests missing the cache for the same message at the same time share
+// a single call.
+var lookups singleflight.Group
+
+// coalesce runs fetch once for all the concurrent callers with the same key
+// and hands each of them its result. fetch runs detached from the context of
+// the caller that started it, so that one client going away doesn't fail
+// the others waiting on it, and is only bounded by REQUEST_TIMEOUT.
+func coalesce[T any](ctx context.Context, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	result := lookups.DoChan(key, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		if config.ValueOf.RequestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.ValueOf.RequestTimeout)
+			defer cancel()
+		}
+		return fetch(ctx)
+	})
+
+	var zero T
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	}
+}
+
+// coalesceWorker coalesces a lookup that only holds for the account of the
+// worker of r, such as a file location. The shared fetch holds the worker
+// itself, as the caller that started it releases it on returning, which
+// may be before the fetch is done.
+func coalesceWorker[T any](ctx context.Context, r *Repository, key string, fetch func(ctx context.Context, r *Repository) (T, error)) (T, error) {
+	return coalesce(ctx, key, func(ctx context.Context) (T, error) {
+		r.worker.acquire()
+		defer r.worker.Release()
+		return fetch(ctx, r)
+	})
+}
+
+// coalesceChannel coalesces a lookup of the channel of r that any worker
+// can make. The shared fetch borrows a worker of its own, as the repository
+// of the caller that started it is closed when that caller returns, which
+// may be before the fetch is done.
+func coalesceChannel[T any](ctx context.Context, r *Repository, key string, fetch func(ctx context.Context, r *Repository) (T, error)) (T, error) {
+	return coalesce(ctx, key, func(ctx context.Context) (T, error) {
+		repository, err := NewChannelRepository(ctx, r.logger, r.channel)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		defer repository.Close()
+
+		return fetch(ctx, repository)
+	})
+}
+
+// historyKey names a page of the history of channelID for coalesce.
+func historyKey(channelID int64, offsetID, limit int) string {
+	return fmt.Sprintf("history:%d:%d:%d", channelID, offsetID, limit)
+}
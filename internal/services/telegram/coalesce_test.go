@@ -0,0 +1,45 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCoalesceWorker(t *testing.T) {
+	worker := &Worker{}
+	r := &Repository{worker: worker}
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	fetch := func(ctx context.Context, r *Repository) (int, error) {
+		close(started)
+		<-finish
+		return 42, nil
+	}
+
+	// the caller that starts the fetch goes away before it's done
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := coalesceWorker(ctx, r, "test:worker", fetch)
+		errs <- err
+	}()
+	<-started
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("coalesceWorker() error = %v, want context.Canceled", err)
+	}
+	if got := worker.InFlight(); got != 1 {
+		t.Errorf("worker has %d requests in flight while the fetch runs, want 1", got)
+	}
+
+	close(finish)
+	for deadline := time.Now().Add(time.Second); worker.InFlight() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker has %d requests in flight after the fetch, want 0", worker.InFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,228 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+const (
+	healthCheckTimeout  = 15 * time.Second
+	workerStartTimeout  = 30 * time.Second
+	initialRetryBackoff = 30 * time.Second
+	maxRetryBackoff     = 30 * time.Minute
+)
+
+var errHealthCheckTimeout = errors.New("health check timed out")
+
+// workerHealth tracks the failures of a worker and acts as its circuit
+// breaker: once CIRCUIT_BREAKER_THRESHOLD consecutive failures are seen the
+// worker is taken out of rotation for CIRCUIT_BREAKER_COOLDOWN, after which a
+// single further failure opens the circuit again until a check succeeds.
+type workerHealth struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	circuitOpenUntil    time.Time
+	floodWaitUntil      time.Time
+	lastError           string
+	lastErrorAt         time.Time
+	lastCheckAt         time.Time
+}
+
+// Healthy reports whether the worker can be handed out by the pool.
+func (w *Worker) Healthy() bool {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	now := time.Now()
+	return now.After(w.health.circuitOpenUntil) && now.After(w.health.floodWaitUntil)
+}
+
+func (w *Worker) reportSuccess() {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	if w.health.consecutiveFailures >= config.ValueOf.CircuitBreakerThreshold {
+		w.log.Info("worker recovered, closing circuit", zap.Int("worker_id", w.Id))
+	}
+	w.health.consecutiveFailures = 0
+	w.health.circuitOpenUntil = time.Time{}
+	w.health.lastCheckAt = time.Now()
+}
+
+// reportFailure records err against the worker if it means the worker itself
+// is unavailable. Flood waits bench the worker until they expire without
+// counting towards the circuit breaker.
+func (w *Worker) reportFailure(err error) {
+	if !isWorkerUnavailable(err) {
+		return
+	}
+
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	now := time.Now()
+	w.health.lastError = err.Error()
+	w.health.lastErrorAt = now
+
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		w.health.floodWaitUntil = now.Add(wait)
+		w.log.Warn("worker is flood waiting", zap.Int("worker_id", w.Id), zap.Duration("wait", wait))
+		return
+	}
+
+	w.health.consecutiveFailures++
+	if w.health.consecutiveFailures >= config.ValueOf.CircuitBreakerThreshold {
+		w.health.circuitOpenUntil = now.Add(config.ValueOf.CircuitBreakerCooldown)
+		w.log.Warn("worker unhealthy, opening circuit",
+			zap.Int("worker_id", w.Id),
+			zap.Int("failures", w.health.consecutiveFailures),
+			zap.Time("until", w.health.circuitOpenUntil),
+		)
+	}
+}
+
+// Status returns a snapshot of the worker state.
+func (w *Worker) Status() models.WorkerStatus {
+	healthy := w.Healthy()
+
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	return models.WorkerStatus{
+		ID:                  w.Id,
+		Username:            w.Self.Username,
+		DC:                  w.Client.Config().ThisDC,
+		Healthy:             healthy,
+		InFlight:            w.InFlight(),
+		ConsecutiveFailures: w.health.consecutiveFailures,
+		LastError:           w.health.lastError,
+		CircuitOpenUntil:    optionalTime(w.health.circuitOpenUntil),
+		FloodWaitUntil:      optionalTime(w.health.floodWaitUntil),
+		LastErrorAt:         optionalTime(w.health.lastErrorAt),
+		LastCheckAt:         optionalTime(w.health.lastCheckAt),
+	}
+}
+
+// Status returns a snapshot of every worker and of the sessions that are
+// still being retried.
+func (w *UserWorkers) Status() *models.WorkersStatus {
+	w.mut.Lock()
+	users := append([]*Worker(nil), w.Users...)
+	w.mut.Unlock()
+
+	status := &models.WorkersStatus{
+		Workers:         make([]models.WorkerStatus, 0, len(users)),
+		PendingSessions: int(w.pending.Load()),
+	}
+	for _, worker := range users {
+		status.Workers = append(status.Workers, worker.Status())
+	}
+	return status
+}
+
+// StartHealthMonitor pings every worker each HEALTH_CHECK_INTERVAL until ctx
+// is done.
+func (w *UserWorkers) StartHealthMonitor(ctx context.Context) {
+	interval := config.ValueOf.HealthCheckInterval
+	if interval <= 0 {
+		w.log.Sugar().Info("HEALTH_CHECK_INTERVAL not set, health monitor disabled")
+		return
+	}
+
+	w.log.Sugar().Infof("health monitor started, checking every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.checkHealth(ctx)
+			}
+		}
+	}()
+}
+
+func (w *UserWorkers) checkHealth(ctx context.Context) {
+	w.mut.Lock()
+	users := append([]*Worker(nil), w.Users...)
+	w.mut.Unlock()
+
+	var wg sync.WaitGroup
+	for _, worker := range users {
+		worker.health.mu.Lock()
+		floodWaiting := time.Now().Before(worker.health.floodWaitUntil)
+		worker.health.mu.Unlock()
+		if floodWaiting {
+			continue
+		}
+
+		wg.Add(1)
+		go func(worker *Worker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			if _, err := worker.Client.API().UpdatesGetState(ctx); err != nil {
+				if ctx.Err() != nil {
+					err = errHealthCheckTimeout
+				}
+				worker.reportFailure(err)
+				w.log.Warn("worker health check failed", zap.Int("worker_id", worker.Id), zap.Error(err))
+				return
+			}
+			worker.reportSuccess()
+		}(worker)
+	}
+	wg.Wait()
+}
+
+// retryStart keeps trying to start the worker id for session with an
+// exponential backoff until it succeeds.
+func (w *UserWorkers) retryStart(id int, session string) {
+	w.pending.Add(1)
+	defer w.pending.Add(-1)
+
+	backoff := initialRetryBackoff
+	for attempt := 1; ; attempt++ {
+		w.log.Sugar().Infof("retrying worker %d in %s (attempt %d)", id, backoff, attempt)
+		time.Sleep(backoff)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- w.start(id, session)
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				w.log.Sugar().Infof("worker %d started after %d retries", id, attempt)
+				return
+			}
+			w.log.Error("failed to start worker", zap.Int("worker_id", id), zap.Error(err))
+		case <-time.After(workerStartTimeout):
+			w.log.Error("timed out starting worker", zap.Int("worker_id", id))
+			// the attempt is still running; only retry again if it fails
+			if err := <-done; err == nil {
+				return
+			}
+		}
+
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"go-winx-api/config"
+
+	"github.com/gotd/td/pool"
+	"github.com/gotd/td/rpc"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+func TestIsWorkerUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+
+		// the worker can't serve requests
+		{name: "flood wait", err: tgerr.New(420, "FLOOD_WAIT_30"), want: true},
+		{name: "unregistered auth key", err: tgerr.New(401, "AUTH_KEY_UNREGISTERED"), want: true},
+		{name: "revoked session", err: tgerr.New(401, "SESSION_REVOKED"), want: true},
+		{name: "deactivated user", err: tgerr.New(401, "USER_DEACTIVATED"), want: true},
+		{name: "chunk timeout", err: errChunkTimeout, want: true},
+		{name: "dead connection", err: fmt.Errorf("invoke: %w", pool.ErrConnDead), want: true},
+		{name: "closed engine", err: fmt.Errorf("invoke: %w", rpc.ErrEngineClosed), want: true},
+		{name: "closed connection", err: fmt.Errorf("read: %w", net.ErrClosed), want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", errors.New("connection reset by peer"))}, want: true},
+		{name: "unexpected eof", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), want: true},
+
+		// the request is wrong or went away
+		{name: "canceled", err: fmt.Errorf("invoke: %w", context.Canceled), want: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: false},
+		{name: "post not found", err: ErrPostNotFound, want: false},
+		{name: "refresh of a deleted message", err: fmt.Errorf("%w: %w", errRefreshFailed, ErrPostNotFound), want: false},
+		{name: "refresh over a dead connection", err: fmt.Errorf("%w: %w", errRefreshFailed, pool.ErrConnDead), want: false},
+		{name: "expired file reference", err: tgerr.New(400, "FILE_REFERENCE_EXPIRED"), want: false},
+		{name: "invalid location", err: tgerr.New(400, "LOCATION_INVALID"), want: false},
+		{name: "private channel", err: tgerr.New(400, "CHANNEL_PRIVATE"), want: false},
+		{name: "internal server error", err: tgerr.New(500, "INTERNAL"), want: false},
+		{name: "other error", err: errors.New("unexpected response type from Telegram API"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isWorkerUnavailable(tt.err); got != tt.want {
+				t.Errorf("isWorkerUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportFailureCircuitBreaker(t *testing.T) {
+	threshold, cooldown := config.ValueOf.CircuitBreakerThreshold, config.ValueOf.CircuitBreakerCooldown
+	config.ValueOf.CircuitBreakerThreshold, config.ValueOf.CircuitBreakerCooldown = 3, time.Minute
+	t.Cleanup(func() {
+		config.ValueOf.CircuitBreakerThreshold, config.ValueOf.CircuitBreakerCooldown = threshold, cooldown
+	})
+
+	// a stream of a deleted video fails every chunk the same way
+	w := &Worker{log: zap.NewNop()}
+	for range 10 {
+		w.reportFailure(fmt.Errorf("%w: %w", errRefreshFailed, ErrPostNotFound))
+	}
+	if !w.Healthy() {
+		t.Fatal("worker benched for a deleted message")
+	}
+
+	for range 3 {
+		w.reportFailure(pool.ErrConnDead)
+	}
+	if w.Healthy() {
+		t.Error("worker still in rotation after its connection died three times")
+	}
+}
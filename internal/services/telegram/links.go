@@ -0,0 +1,152 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+)
+
+// Kinds of media links, which are the path segment they're served under.
+const (
+	MediaImages    = "images"
+	MediaVideos    = "videos"
+	MediaDownloads = "downloads"
+)
+
+var (
+	ErrLinkRequired     = errors.New("media link must be signed")
+	ErrLinkHashMismatch = errors.New("media link hash does not match the file")
+	ErrLinkSignature    = errors.New("media link signature is invalid")
+	ErrLinkExpired      = errors.New("media link has expired")
+)
+
+// GetImageURL returns the link to the image of messageID in channel. hash is
+// the packed hash of the photo, embedded in the link when SIGNED_LINKS is
+// enabled.
+func GetImageURL(channel *models.Channel, messageID int, hash string) string {
+	return mediaURL(channel, MediaImages, messageID, hash)
+}
+
+// GetVideoURL returns the link to the video of messageID in channel. hash is
+// the packed hash of the document, embedded in the link when SIGNED_LINKS is
+// enabled.
+func GetVideoURL(channel *models.Channel, messageID int, hash string) string {
+	return mediaURL(channel, MediaVideos, messageID, hash)
+}
+
+// GetDownloadURL returns the link to download the document of messageID in
+// channel as an attachment.
+func GetDownloadURL(channel *models.Channel, messageID int, hash string) string {
+	return mediaURL(channel, MediaDownloads, messageID, hash)
+}
+
+// mediaURL links to the media of the default channel under /api/v1/posts,
+// and to that of the others under their slug.
+func mediaURL(channel *models.Channel, kind string, messageID int, hash string) string {
+	prefix := "/api/v1"
+	if channel.ID != DefaultChannel().ID {
+		prefix += "/channels/" + url.PathEscape(channel.Slug)
+	}
+	link := fmt.Sprintf(config.ValueOf.Host+prefix+"/posts/%s/%d", kind, messageID)
+	if !config.ValueOf.SignedLinks || hash == "" {
+		return link
+	}
+
+	query := url.Values{}
+	query.Set("hash", shortHash(hash))
+	if config.ValueOf.LinkTTL > 0 {
+		expires := time.Now().Add(config.ValueOf.LinkTTL).Unix()
+		query.Set("expires", strconv.FormatInt(expires, 10))
+		query.Set("signature", signLink(channel, kind, messageID, shortHash(hash), expires))
+	}
+	return link + "?" + query.Encode()
+}
+
+// setMediaURLs sets the channel slug of post and (re)builds its media links,
+// so that links served from cached posts carry a fresh expiry. The links
+// don't carry the token of a restricted channel, which has to be sent with
+// them like with any other route.
+func setMediaURLs(post *models.Post) {
+	channel := postChannel(post)
+	post.Channel = channel.Slug
+	post.ImageURL = GetImageURL(channel, post.MessageID, post.ImageHash)
+	if post.DocumentMessageID != 0 {
+		post.VideoURL = GetVideoURL(channel, post.DocumentMessageID, post.DocumentHash)
+		post.DownloadURL = GetDownloadURL(channel, post.DocumentMessageID, post.DocumentHash)
+	}
+	for i := range post.Files {
+		file := &post.Files[i]
+		file.VideoURL = GetVideoURL(channel, file.MessageID, file.Hash)
+		file.DownloadURL = GetDownloadURL(channel, file.MessageID, file.Hash)
+	}
+}
+
+// CheckMediaLink checks the expires and signature query parameters of a
+// media link of kind to messageID in channel, which don't depend on the
+// file it points to, so that links that can't be valid are turned away
+// before the file is looked up. Links without a hash are only accepted when
+// REQUIRE_SIGNED_LINKS is off.
+func CheckMediaLink(channel *models.Channel, kind string, messageID int, hash, expires, signature string) error {
+	if hash == "" {
+		if config.ValueOf.RequireSignedLinks {
+			return ErrLinkRequired
+		}
+		return nil
+	}
+
+	if expires == "" {
+		if config.ValueOf.RequireSignedLinks && config.ValueOf.LinkTTL > 0 {
+			return ErrLinkRequired
+		}
+		return nil
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrLinkSignature
+	}
+	expected := signLink(channel, kind, messageID, hash, expiresAt)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrLinkSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+// VerifyLinkHash checks the hash of a media link that passed CheckMediaLink
+// against fileHash, the packed hash of the file it points to.
+func VerifyLinkHash(fileHash, hash string) error {
+	if hash == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(shortHash(fileHash))) != 1 {
+		return ErrLinkHashMismatch
+	}
+	return nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > config.ValueOf.HashLength {
+		return hash[:config.ValueOf.HashLength]
+	}
+	return hash
+}
+
+// signLink signs a link of kind to messageID in channel, so that it can't
+// be moved to another channel, kind of media or message.
+func signLink(channel *models.Channel, kind string, messageID int, hash string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.LinkSecret))
+	mac.Write([]byte(fmt.Sprintf("%d:%s:%d:%s:%d", channel.ID, kind, messageID, hash, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/models"
+)
+
+func TestCheckMediaLink(t *testing.T) {
+	previous := config.ValueOf
+	t.Cleanup(func() { config.ValueOf = previous })
+	config.ValueOf.Host = "http://localhost:8080"
+	config.ValueOf.SignedLinks = true
+	config.ValueOf.LinkSecret = "secret"
+	config.ValueOf.LinkTTL = time.Hour
+	config.ValueOf.HashLength = 6
+	config.ValueOf.Channels = []models.Channel{{Slug: "filmes", ID: 1}, {Slug: "series", ID: 2}}
+	filmes, series := &config.ValueOf.Channels[0], &config.ValueOf.Channels[1]
+
+	link, err := url.Parse(GetVideoURL(series, 7189, "0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("invalid link: %v", err)
+	}
+	query := link.Query()
+	hash, expires, signature := query.Get("hash"), query.Get("expires"), query.Get("signature")
+
+	tests := []struct {
+		name      string
+		channel   *models.Channel
+		kind      string
+		messageID int
+		hash      string
+		expires   string
+		want      error
+	}{
+		{name: "valid", channel: series, kind: MediaVideos, messageID: 7189, hash: hash, expires: expires},
+		{name: "other channel", channel: filmes, kind: MediaVideos, messageID: 7189, hash: hash, expires: expires, want: ErrLinkSignature},
+		{name: "other kind", channel: series, kind: MediaDownloads, messageID: 7189, hash: hash, expires: expires, want: ErrLinkSignature},
+		{name: "other message", channel: series, kind: MediaVideos, messageID: 7190, hash: hash, expires: expires, want: ErrLinkSignature},
+		{name: "other hash", channel: series, kind: MediaVideos, messageID: 7189, hash: "fedcba", expires: expires, want: ErrLinkSignature},
+		{name: "later expiry", channel: series, kind: MediaVideos, messageID: 7189, hash: hash, expires: expires + "0", want: ErrLinkSignature},
+		{name: "malformed expiry", channel: series, kind: MediaVideos, messageID: 7189, hash: hash, expires: "soon", want: ErrLinkSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckMediaLink(tt.channel, tt.kind, tt.messageID, tt.hash, tt.expires, signature)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CheckMediaLink() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	err = CheckMediaLink(series, MediaVideos, 7189, hash, strconv.FormatInt(expired, 10), signLink(series, MediaVideos, 7189, hash, expired))
+	if !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("CheckMediaLink() of an expired link error = %v, want ErrLinkExpired", err)
+	}
+}
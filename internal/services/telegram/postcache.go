@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go-winx-api/config"
+	"go-winx-api/internal/cache"
+	"go-winx-api/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// ErrPostNotFound is returned for a message that isn't part of a post.
+var ErrPostNotFound = errors.New("message not found")
+
+// postEntry is a post as cached, or the lack of one for a message that
+// isn't part of a post. It's served as is until StaleAt, and after that
+// while it's refreshed in the background, until the cache drops it.
+type postEntry struct {
+	Post    *models.Post
+	StaleAt time.Time
+}
+
+// revalidating holds the cache keys of the posts being
+// refreshed in the background, so each is only refreshed once at a time.
+var revalidating sync.Map
+
+// cachedPost looks messageID of channel up in the cache. It returns nil
+// when it isn't cached, and ErrPostNotFound when it's known not to be a
+// post. A stale post is returned while a fresh one is fetched.
+func cachedPost(logger *zap.Logger, channel *models.Channel, messageID int) (*models.Post, error) {
+	var entry postEntry
+	if cache.Get(cache.GetCache(), cacheKey("post", channel.ID, messageID), &entry) != nil {
+		return nil, nil
+	}
+	if entry.Post == nil {
+		logger.Sugar().Debugf("message %d is cached as not found", messageID)
+		return nil, ErrPostNotFound
+	}
+
+	if time.Now().After(entry.StaleAt) {
+		logger.Sugar().Infof("using stale cached post %d", messageID)
+		revalidatePost(logger, channel, messageID)
+	} else {
+		logger.Sugar().Infof("using cached post %d", messageID)
+	}
+	setMediaURLs(entry.Post)
+	return entry.Post, nil
+}
+
+// cachePost caches post until POST_CACHE_HARD_TTL, fresh for
+// POST_CACHE_SOFT_TTL.
+func cachePost(channelID int64, post *models.Post) error {
+	entry := postEntry{Post: post, StaleAt: time.Now().Add(config.ValueOf.PostCacheSoftTTL)}
+	return cache.Set(cache.GetCache(), cacheKey("post", channelID, post.MessageID), &entry, config.ValueOf.PostCacheHardTTL)
+}
+
+// cacheMissingPost remembers for NEGATIVE_CACHE_TTL that messageID isn't
+// part of a post, so that bad links don't each cost a call to Telegram.
+func cacheMissingPost(channelID int64, messageID int) error {
+	ttl := config.ValueOf.NegativeCacheTTL
+	if ttl <= 0 {
+		return nil
+	}
+	entry := postEntry{StaleAt: time.Now().Add(ttl)}
+	return cache.Set(cache.GetCache(), cacheKey("post", channelID, messageID), &entry, ttl)
+}
+
+// cacheMissingMedia remembers for NEGATIVE_CACHE_TTL that messageID has no
+// media of kind, "file" or "photo", so that video, image and download links
+// to it don't each cost a call to Telegram either.
+func cacheMissingMedia(kind string, channelID int64, messageID int) error {
+	ttl := config.ValueOf.NegativeCacheTTL
+	if ttl <= 0 {
+		return nil
+	}
+	missing := true
+	return cache.Set(cache.GetCache(), cacheKey("missing_"+kind, channelID, messageID), &missing, ttl)
+}
+
+// mediaMissing reports whether messageID is cached as having no media of
+// kind.
+func mediaMissing(kind string, channelID int64, messageID int) bool {
+	var missing bool
+	return cache.Get(cache.GetCache(), cacheKey("missing_"+kind, channelID, messageID), &missing) == nil && missing
+}
+
+// revalidatePost fetches messageID again in the background with a worker
+// of its own, as the request serving the stale post won't wait for it.
+func revalidatePost(logger *zap.Logger, channel *models.Channel, messageID int) {
+	key := cacheKey("post", channel.ID, messageID)
+	if _, busy := revalidating.LoadOrStore(key, true); busy {
+		return
+	}
+
+	go func() {
+		defer revalidating.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+		defer cancel()
+
+		repository, err := NewChannelRepository(ctx, logger, channel)
+		if err != nil {
+			logger.Warn("failed to revalidate post", zap.Int("message_id", messageID), zap.Error(err))
+			return
+		}
+		defer repository.Close()
+
+		if _, err := repository.fetchPost(ctx, messageID); err != nil && !errors.Is(err, ErrPostNotFound) {
+			logger.Warn("failed to revalidate post", zap.Int("message_id", messageID), zap.Error(err))
+		}
+	}()
+}
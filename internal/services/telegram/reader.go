@@ -2,60 +2,187 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"sync"
+	"time"
 
+	"go-winx-api/config"
+	"go-winx-api/internal/cache"
 	"go-winx-api/internal/utils"
 
-	"github.com/celestix/gotgproto"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 )
 
 const defaultChunkSize = int64(1024 * 1024) // 1MB
 
+// maxFailovers bounds how many times a single chunk can move to another worker.
+const maxFailovers = 3
+
+var errChunkTimeout = errors.New("chunk request timed out")
+
+// errRefreshFailed wraps the failure to refresh an expired file reference,
+// which is about the file, such as its message being deleted, rather than
+// the worker that asked.
+var errRefreshFailed = errors.New("failed to refresh file reference")
+
+// chunkSource is a worker together with the document location as seen by
+// the account behind that worker. refresh is called to obtain a new location
+// once the file reference of the current one expires.
+type chunkSource struct {
+	worker   *Worker
+	refresh  func(ctx context.Context) (*tg.InputDocumentFileLocation, error)
+	mu       sync.Mutex
+	location *tg.InputDocumentFileLocation
+}
+
+func (s *chunkSource) currentLocation() *tg.InputDocumentFileLocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.location
+}
+
+// refreshLocation replaces stale with a freshly fetched location. Chunks in
+// flight that fail with the same stale location share a single refresh.
+func (s *chunkSource) refreshLocation(ctx context.Context, stale *tg.InputDocumentFileLocation) (*tg.InputDocumentFileLocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.location != stale {
+		return s.location, nil
+	}
+	if s.refresh == nil {
+		return nil, errors.New("file reference expired and source can't be refreshed")
+	}
+	location, err := s.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.location = location
+	return location, nil
+}
+
+// replacement is a failover of a slot in progress, which the chunks failing
+// on the same source wait for instead of starting their own.
+type replacement struct {
+	source *chunkSource
+	err    error
+	done   chan struct{}
+}
+
+// part is a chunk that has been requested and may still be in flight.
+type part struct {
+	offset int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
 type Reader struct {
 	ctx           context.Context
+	cancel        context.CancelFunc
 	log           *zap.Logger
-	client        *gotgproto.Client
-	location      *tg.InputDocumentFileLocation
+	sources       []*chunkSource
+	documentID    int64
 	start         int64
 	end           int64
-	next          func() ([]byte, error)
 	buffer        []byte
 	bytesRead     int64
 	chunkSize     int64
 	bufferIndex   int64
 	contentLength int64
+	chunkTimeout  time.Duration
+
+	// failover returns a source backed by a worker whose account is not in
+	// the given list, used to replace a source whose worker stopped working.
+	failover  func(ctx context.Context, exclude []int64) (*chunkSource, error)
+	sourcesMu sync.Mutex
+	failed    []int64
+	replacing map[int]*replacement
+	closed    bool
+
+	concurrency int
+	pending     []*part
+	offset      int64
+	partCount   int
+	scheduled   int
+	currentPart int
 }
 
 func NewReader(
 	ctx context.Context,
-	client *gotgproto.Client,
-	location *tg.InputDocumentFileLocation,
+	sources []*chunkSource,
+	documentID int64,
+	failover func(ctx context.Context, exclude []int64) (*chunkSource, error),
 	start, end, contentLength int64,
+	concurrency int,
 ) (io.ReadCloser, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no chunk sources available")
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	reader := &Reader{
 		ctx:           ctx,
+		cancel:        cancel,
 		log:           utils.Logger.Named("telegram_reader"),
-		location:      location,
-		client:        client,
+		sources:       sources,
+		documentID:    documentID,
+		failover:      failover,
 		start:         start,
 		end:           end,
 		chunkSize:     defaultChunkSize,
 		contentLength: contentLength,
+		chunkTimeout:  config.ValueOf.StreamChunkTimeout,
+		concurrency:   concurrency,
 	}
 
 	reader.log.Sugar().Debug("starting telegram reader")
 	reader.log.Sugar().Debug("content length", contentLength)
 	reader.log.Sugar().Debug("start", start)
 	reader.log.Sugar().Debug("end", end)
+	reader.log.Sugar().Debug("sources", len(sources))
+	reader.log.Sugar().Debug("concurrency", concurrency)
+
+	reader.offset = start - (start % reader.chunkSize)
+	reader.partCount = int((end - reader.offset + reader.chunkSize) / reader.chunkSize)
+	reader.currentPart = 1
 
-	reader.next = reader.partStream()
 	return reader, nil
 }
 
-func (*Reader) Close() error {
+// Close stops the chunks still in flight and hands the workers used by the
+// stream back to the pool. It's called by fasthttp once the body is sent or
+// the client goes away.
+func (r *Reader) Close() error {
+	// cancelling first stops a failover in flight, which may be holding
+	// up the chunks waited for below
+	r.cancel()
+
+	r.sourcesMu.Lock()
+	if r.closed {
+		r.sourcesMu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.sourcesMu.Unlock()
+
+	for _, p := range r.pending {
+		<-p.done
+	}
+	r.pending = nil
+
+	r.sourcesMu.Lock()
+	defer r.sourcesMu.Unlock()
+	for _, source := range r.sources {
+		source.worker.Release()
+	}
+	r.log.Debug("reader closed", zap.Int64("bytesRead", r.bytesRead))
 	return nil
 }
 
@@ -70,18 +197,17 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		r.log.Debug("next Buffer", zap.Int64("len", int64(len(r.buffer))))
 
 		if err != nil {
-			r.log.Error("error fetching next buffer", zap.Error(err))
+			if r.ctx.Err() != nil {
+				r.log.Debug("stream cancelled", zap.Int64("bytesRead", r.bytesRead))
+			} else {
+				r.log.Error("error fetching next buffer", zap.Error(err))
+			}
 			return 0, err
 		}
 
 		if len(r.buffer) == 0 {
-			r.log.Sugar().Warn("buffer is empty, resetting partStream")
-			r.next = r.partStream()
-			r.buffer, err = r.next()
-			if err != nil {
-				r.log.Error("error fetching buffer after resetting partStream", zap.Error(err))
-				return 0, err
-			}
+			r.log.Sugar().Warn("buffer is empty before the requested range was read")
+			return 0, io.ErrUnexpectedEOF
 		}
 		r.bufferIndex = 0
 	}
@@ -94,16 +220,129 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
-func (r *Reader) chunk(offset int64, limit int64) ([]byte, error) {
+// chunk serves a chunk from the chunk cache, or else fetches it through the
+// source in the given slot and caches it, handing the slot over to another
+// worker whenever the current one becomes unavailable.
+func (r *Reader) chunk(slot int, offset int64, limit int64) ([]byte, error) {
+	key := cache.ChunkKey{Kind: cache.ChunkDocument, FileID: r.documentID, Offset: offset}
+	if data, ok := cache.GetChunkCache().Get(key); ok {
+		r.log.Sugar().Debugf("chunk served from cache: Offset=%d", offset)
+		return data, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		source := r.source(slot)
+		res, err := r.fetch(source, offset, limit)
+		if err == nil {
+			if err := cache.GetChunkCache().Put(key, res); err != nil {
+				r.log.Warn("failed to cache chunk", zap.Int64("offset", offset), zap.Error(err))
+			}
+			return res, nil
+		}
+		if !isWorkerUnavailable(err) || r.ctx.Err() != nil || attempt >= maxFailovers {
+			return nil, err
+		}
+
+		source.worker.reportFailure(err)
+		r.log.Warn("worker unavailable, failing over",
+			zap.Int64("worker", source.worker.Self.ID),
+			zap.Int64("offset", offset),
+			zap.Error(err),
+		)
+		if _, failoverErr := r.replaceSource(slot, source); failoverErr != nil {
+			r.log.Error("failed to fail over", zap.Error(failoverErr))
+			return nil, err
+		}
+	}
+}
+
+func (r *Reader) source(slot int) *chunkSource {
+	r.sourcesMu.Lock()
+	defer r.sourcesMu.Unlock()
+	return r.sources[slot]
+}
+
+// replaceSource swaps the failed source in slot with one from another worker.
+// Chunks in flight that fail on the same source share a single replacement,
+// which runs without holding sourcesMu so that the other slots and Close
+// aren't held up by it.
+func (r *Reader) replaceSource(slot int, failed *chunkSource) (*chunkSource, error) {
+	r.sourcesMu.Lock()
+	if r.closed {
+		r.sourcesMu.Unlock()
+		return nil, errors.New("reader is closed")
+	}
+	if r.sources[slot] != failed {
+		source := r.sources[slot]
+		r.sourcesMu.Unlock()
+		return source, nil
+	}
+	if r.failover == nil {
+		r.sourcesMu.Unlock()
+		return nil, errors.New("failover is not configured")
+	}
+	if pending, ok := r.replacing[slot]; ok {
+		r.sourcesMu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.source, pending.err
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+	}
+
+	pending := &replacement{done: make(chan struct{})}
+	if r.replacing == nil {
+		r.replacing = make(map[int]*replacement)
+	}
+	r.replacing[slot] = pending
+	r.failed = append(r.failed, failed.worker.Self.ID)
+	exclude := slices.Clone(r.failed)
+	r.sourcesMu.Unlock()
+
+	source, err := r.failover(r.ctx, exclude)
+
+	r.sourcesMu.Lock()
+	defer r.sourcesMu.Unlock()
+	delete(r.replacing, slot)
+	switch {
+	case err != nil:
+	case r.closed:
+		// Close releases the sources in place, which the new one isn't
+		source.worker.Release()
+		source, err = nil, errors.New("reader is closed")
+	default:
+		r.sources[slot] = source
+		failed.worker.Release()
+	}
+	pending.source, pending.err = source, err
+	close(pending.done)
+	return source, err
+}
+
+func (r *Reader) fetch(source *chunkSource, offset int64, limit int64) ([]byte, error) {
 	r.log.Sugar().Debugf("requesting chunk: Offset=%d, Limit=%d", offset, limit)
 	req := &tg.UploadGetFileRequest{
 		Offset:   offset,
 		Limit:    int(limit),
-		Location: r.location,
+		Location: source.currentLocation(),
 	}
 
-	res, err := r.client.API().UploadGetFile(r.ctx, req)
+	res, err := r.uploadGetFile(source, req)
+	if isFileReferenceExpired(err) {
+		r.log.Info("file reference expired, refreshing", zap.Int64("offset", offset))
+		location, refreshErr := source.refreshLocation(r.ctx, req.Location.(*tg.InputDocumentFileLocation))
+		if refreshErr != nil {
+			r.log.Error("failed to refresh file reference", zap.Error(refreshErr))
+			return nil, fmt.Errorf("%w: %w", errRefreshFailed, refreshErr)
+		}
+		req.Location = location
+		res, err = r.uploadGetFile(source, req)
+	}
 	if err != nil {
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
 		r.log.Error("failed to fetch chunk", zap.Error(err))
 		return nil, err
 	}
@@ -122,44 +361,85 @@ func (r *Reader) chunk(offset int64, limit int64) ([]byte, error) {
 	}
 }
 
-func (r *Reader) partStream() func() ([]byte, error) {
-	start := r.start
-	end := r.end
-	offset := start - (start % r.chunkSize)
+// uploadGetFile requests a chunk, giving up after STREAM_CHUNK_TIMEOUT. A
+// timed out request is reported as errChunkTimeout rather than a context
+// error, so a stalled worker is failed over like any other unavailable one.
+func (r *Reader) uploadGetFile(source *chunkSource, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	if r.chunkTimeout <= 0 {
+		return source.worker.Client.API().UploadGetFile(r.ctx, req)
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.chunkTimeout)
+	defer cancel()
 
-	firstPartCut := start - offset
-	lastPartCut := (end % r.chunkSize) + 1
-	partCount := int((end - offset + r.chunkSize) / r.chunkSize)
-	currentPart := 1
+	res, err := source.worker.Client.API().UploadGetFile(ctx, req)
+	if err != nil && ctx.Err() != nil && r.ctx.Err() == nil {
+		return nil, errChunkTimeout
+	}
+	return res, err
+}
 
-	return func() ([]byte, error) {
-		if currentPart > partCount {
-			r.log.Debug("all parts have been read")
-			return make([]byte, 0), nil
+// prefetch keeps up to r.concurrency chunks in flight. Chunks are spread
+// round-robin across the reader sources and reassembled in order by next.
+func (r *Reader) prefetch() {
+	for len(r.pending) < r.concurrency && r.scheduled < r.partCount {
+		p := &part{
+			offset: r.offset,
+			done:   make(chan struct{}),
 		}
+		slot := r.scheduled % len(r.sources)
 
-		r.log.Sugar().Debugf("fetching part %d/%d, Offset=%d", currentPart, partCount, offset)
+		go func() {
+			defer close(p.done)
+			p.data, p.err = r.chunk(slot, p.offset, r.chunkSize)
+		}()
 
-		res, err := r.chunk(offset, r.chunkSize)
-		if err != nil {
-			r.log.Error("failed to fetch chunk", zap.Error(err))
-			return nil, err
-		}
+		r.pending = append(r.pending, p)
+		r.scheduled++
+		r.offset += r.chunkSize
+	}
+}
 
-		if len(res) == 0 {
-			return res, nil
-		}
+func (r *Reader) next() ([]byte, error) {
+	if r.currentPart > r.partCount {
+		r.log.Debug("all parts have been read")
+		return make([]byte, 0), nil
+	}
 
-		if partCount == 1 {
-			res = res[firstPartCut:lastPartCut]
-		} else if currentPart == 1 {
-			res = res[firstPartCut:]
-		} else if currentPart == partCount {
-			res = res[:lastPartCut]
-		}
+	r.prefetch()
+
+	p := r.pending[0]
+	select {
+	case <-p.done:
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+	r.pending = r.pending[1:]
+	r.prefetch()
+
+	r.log.Sugar().Debugf("read part %d/%d, Offset=%d", r.currentPart, r.partCount, p.offset)
+
+	if p.err != nil {
+		r.log.Error("failed to fetch chunk", zap.Error(p.err))
+		return nil, p.err
+	}
 
-		currentPart++
-		offset += r.chunkSize
+	res := p.data
+	if len(res) == 0 {
 		return res, nil
 	}
+
+	firstPartCut := r.start % r.chunkSize
+	lastPartCut := (r.end % r.chunkSize) + 1
+
+	if r.partCount == 1 {
+		res = res[firstPartCut:min(lastPartCut, int64(len(res)))]
+	} else if r.currentPart == 1 {
+		res = res[firstPartCut:]
+	} else if r.currentPart == r.partCount {
+		res = res[:min(lastPartCut, int64(len(res)))]
+	}
+
+	r.currentPart++
+	return res, nil
 }
@@ -1,46 +1,108 @@
 package telegram
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"github.com/gotd/td/telegram/downloader"
-	"go-winx-api/internal/cache"
 	"io"
+	"net"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"go-winx-api/config"
+	"go-winx-api/internal/cache"
+	"go-winx-api/internal/index"
 	"go-winx-api/internal/models"
 	"go-winx-api/internal/utils"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/pool"
+	"github.com/gotd/td/rpc"
+	"github.com/gotd/td/telegram/downloader"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
 )
 
+// maxAlbumSize is the most messages Telegram allows in a single album, and
+// the most a post is put together from.
+const maxAlbumSize = 10
+
+// maxGetMessages is the most messages ChannelsGetMessages returns at once.
+const maxGetMessages = 100
+
+// cacheTTL is how long posts and file metadata stay cached.
+const cacheTTL = 12 * time.Hour
+
 type Repository struct {
-	client *gotgproto.Client
-	logger *zap.Logger
-	peer   tg.InputPeerClass
+	client  *gotgproto.Client
+	logger  *zap.Logger
+	worker  *Worker
+	channel *models.Channel
+	input   *tg.InputChannel
 }
 
-func NewRepository(logger *zap.Logger) *Repository {
-	worker := GetNextWorker()
+// NewRepository borrows a worker from the pool and binds a repository to it
+// and the default channel for the duration of a request. Close hands the
+// worker back.
+func NewRepository(ctx context.Context, logger *zap.Logger) (*Repository, error) {
+	return NewChannelRepository(ctx, logger, DefaultChannel())
+}
 
-	err := refreshAccessHash(context.Background(), worker.Client, logger)
-	if err != nil {
-		logger.Error("failed to refresh access hash", zap.Error(err))
+// NewChannelRepository borrows a worker that can access channel from the
+// pool and binds a repository to both. Close hands the worker back. It fails
+// with ErrNoWorkers only when the pool has no worker to give, and with
+// ErrChannelUnavailable, wrapping the error of the last worker tried, when
+// none of them could access the channel.
+func NewChannelRepository(ctx context.Context, logger *zap.Logger, channel *models.Channel) (*Repository, error) {
+	var failed []int64
+	var lastErr error
+	for {
+		worker, err := Workers.Acquire(failed...)
+		if err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrChannelUnavailable, channel.Slug, lastErr)
+			}
+			return nil, err
+		}
+
+		repo, err := newWorkerRepository(ctx, logger, worker, channel)
+		if err == nil {
+			return repo, nil
+		}
+		worker.Release()
+
+		// the request going away isn't the fault of the worker
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Warn("worker can't access channel", zap.Int("worker_id", worker.Id), zap.String("channel", channel.Slug), zap.Error(err))
+		failed = append(failed, worker.Self.ID)
+		lastErr = err
 	}
+}
 
-	peer := worker.Client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
+func newWorkerRepository(ctx context.Context, logger *zap.Logger, worker *Worker, channel *models.Channel) (*Repository, error) {
+	input, err := worker.InputChannel(ctx, channel.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Repository{
-		client: worker.Client,
-		logger: logger,
-		peer:   peer,
-	}
+		client:  worker.Client,
+		logger:  logger,
+		worker:  worker,
+		channel: channel,
+		input:   input,
+	}, nil
+}
+
+func (r *Repository) Close() {
+	r.worker.Release()
 }
 
 func (r *Repository) GetClient() *gotgproto.Client {
@@ -48,15 +110,15 @@ func (r *Repository) GetClient() *gotgproto.Client {
 }
 
 func (r *Repository) GetHistory(ctx context.Context, limit int, offsetID int) ([]*tg.Message, error) {
-	peerClass := r.client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
 	history, err := r.client.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-		Peer:     peerClass,
+		Peer:     &tg.InputPeerChannel{ChannelID: r.input.ChannelID, AccessHash: r.input.AccessHash},
 		Limit:    limit,
 		OffsetID: offsetID,
 		MaxID:    0,
 		MinID:    0,
 	})
 	if err != nil {
+		r.handleWorkerError(err)
 		r.logger.Error("failed to get history", zap.Error(err))
 		return nil, err
 	}
@@ -73,86 +135,102 @@ func (r *Repository) GetHistory(ctx context.Context, limit int, offsetID int) ([
 	return messages, nil
 }
 
-func (r *Repository) GroupedPosts(ctx context.Context, pagination models.PaginationData) (map[int64][]*tg.Message, error) {
-	limit := pagination.PerPage
-	offsetID := pagination.OffsetId
-	totalGroupsNeeded := limit
-
-	groupedMessages := make(map[int64][]*tg.Message)
-
-	maxLoops := 30
-
-	for len(groupedMessages) < totalGroupsNeeded && maxLoops > 0 {
-		currentLimit := max(20, limit*2)
+// GroupedPosts walks the history older than offsetID and returns the
+// messages of up to limit posts, newest first, along with the offset the
+// next page starts from, or 0 when the start of the history was reached. A
+// post is only returned once a message older than it was seen, or the start
+// of the history was reached, so that it's never split between two pages.
+func (r *Repository) GroupedPosts(ctx context.Context, offsetID, limit int) ([][]*tg.Message, int, error) {
+	var messages []*tg.Message
+	oldest := 0
+	exhausted := false
 
-		allMessages, err := r.GetHistory(ctx, currentLimit, offsetID)
+	for maxLoops := 30; maxLoops > 0 && len(completeGroups(messages, oldest, exhausted)) < limit; maxLoops-- {
+		pageSize := max(20, limit*2)
+		page, err := coalesceChannel(ctx, r, historyKey(r.channel.ID, offsetID, pageSize), func(ctx context.Context, r *Repository) ([]*tg.Message, error) {
+			return r.GetHistory(ctx, pageSize, offsetID)
+		})
 		if err != nil {
-			return nil, err
-		}
-
-		if len(allMessages) == 0 {
-			maxLoops--
-			offsetID--
-			continue
+			return nil, 0, err
 		}
 
-		for _, msg := range allMessages {
-			if msg.GroupedID != 0 {
-				groupedMessages[msg.GroupedID] = append(groupedMessages[msg.GroupedID], msg)
-			}
+		if len(page) == 0 {
+			exhausted = true
+			break
 		}
 
-		minID := allMessages[0].ID
-		for _, msg := range allMessages {
-			if msg.ID < minID {
-				minID = msg.ID
+		for _, msg := range page {
+			if oldest == 0 || msg.ID < oldest {
+				oldest = msg.ID
 			}
 		}
-		offsetID = minID - 1
-		maxLoops--
+		messages = append(messages, page...)
+		offsetID = oldest
 	}
 
-	if len(groupedMessages) > totalGroupsNeeded {
-		groupedMessages = limitGroupsByMostRecent(groupedMessages, totalGroupsNeeded)
+	groups := completeGroups(messages, oldest, exhausted)
+	if len(groups) <= limit && exhausted {
+		return groups, 0, nil
+	}
+	if len(groups) > limit {
+		groups = groups[:limit]
 	}
 
-	return groupedMessages, nil
+	// the next page starts after the last post returned or, when the walk
+	// gave up before finding any, early enough to take in the post the
+	// oldest message scanned may be part of
+	if len(groups) > 0 {
+		return groups, groups[len(groups)-1][0].ID, nil
+	}
+	return groups, oldest + maxAlbumSize, nil
 }
 
 func (r *Repository) PaginatePosts(ctx context.Context, pagination models.PaginationData) (*models.PaginatedPosts, error) {
-	groupedMessages, err := r.GroupedPosts(ctx, pagination)
+	cursor, err := models.ParseCursor(pagination.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// the history can only be walked back in time, so there are no cursors
+	// to previous pages
+	if cursor.Prev {
+		return nil, models.ErrInvalidCursor
+	}
+	offsetID := pagination.OffsetId
+	if pagination.Cursor != "" {
+		offsetID = cursor.ID
+	}
+
+	groups, next, err := r.GroupedPosts(ctx, offsetID, pagination.PerPage)
 	if err != nil {
 		return nil, err
 	}
 
 	var posts []models.Post
-	for _, group := range groupedMessages {
-		post := createPostFromMessages(group)
+	for _, group := range groups {
+		post := createPostFromMessages(r.channel, group)
 		if post != nil {
 			posts = append(posts, *post)
 		}
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].MessageID > posts[j].MessageID
-	})
-
-	total := len(posts)
-
-	if total > 0 {
+	pagination.Total, err = r.countMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) > 0 {
 		pagination.FirstOffsetId = posts[0].MessageID
-		pagination.LastOffsetId = posts[total-1].MessageID
-		pagination.Total = total
+		pagination.LastOffsetId = posts[len(posts)-1].MessageID
 	} else {
 		pagination.FirstOffsetId = 0
 		pagination.LastOffsetId = 0
-		pagination.Total = 0
+	}
+	if next > 0 {
+		pagination.NextCursor = models.Cursor{ID: next}.Encode()
 	}
 
-	// cache posts for 12 hours
 	for _, post := range posts {
-		key := fmt.Sprintf("post:%d:%d", post.MessageID, r.client.Self.ID)
-		err = cache.GetCache().SetPost(key, &post, 3600*12)
+		err = cachePost(r.channel.ID, &post)
 		if err != nil {
 			r.logger.Error("failed to cache post", zap.Error(err))
 		}
@@ -164,95 +242,433 @@ func (r *Repository) PaginatePosts(ctx context.Context, pagination models.Pagina
 	}, nil
 }
 
-func (r *Repository) GetPost(ctx context.Context, messageID int) (*models.Post, error) {
-	key := fmt.Sprintf("post:%d:%d", messageID, r.client.Self.ID)
-	var cachedPost models.Post
-	err := cache.GetCache().GetPost(key, &cachedPost)
-	if err == nil {
-		r.logger.Sugar().Info("using cached post", messageID, r.client.Self.ID)
-		return &cachedPost, nil
+// countMessages returns the number of messages in the history of the
+// channel, which Telegram sends along with a page of it, so that a single
+// message is enough to learn it. It counts every message rather than the
+// posts they make up, as that would take walking the whole history.
+func (r *Repository) countMessages(ctx context.Context) (int, error) {
+	history, err := r.client.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+		Peer:  &tg.InputPeerChannel{ChannelID: r.input.ChannelID, AccessHash: r.input.AccessHash},
+		Limit: 1,
+	})
+	if err != nil {
+		r.handleWorkerError(err)
+		r.logger.Error("failed to count messages", zap.Error(err))
+		return 0, err
 	}
 
-	peerClass := r.client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
-	if peerClass == nil {
-		r.logger.Error("channel not configured in PeerStorage")
-		return nil, errors.New("channel not configured")
+	switch result := history.(type) {
+	case *tg.MessagesChannelMessages:
+		return result.Count, nil
+	case *tg.MessagesMessagesSlice:
+		return result.Count, nil
+	case *tg.MessagesMessages:
+		return len(result.Messages), nil
+	}
+	return 0, nil
+}
+
+// SearchPosts searches the captions of the channel with MessagesSearch and
+// builds the posts of the messages found, newest first.
+func (r *Repository) SearchPosts(ctx context.Context, pagination models.PaginationData) (*models.PaginatedPosts, error) {
+	cursor, err := models.ParseCursor(pagination.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	offsetID, addOffset := pagination.OffsetId, pagination.AddOffset
+	if pagination.Cursor != "" {
+		offsetID, addOffset = cursor.ID, 0
 	}
 
-	inputChannel, ok := peerClass.(*tg.InputPeerChannel)
+	result, err := r.client.API().MessagesSearch(ctx, &tg.MessagesSearchRequest{
+		Peer:      &tg.InputPeerChannel{ChannelID: r.input.ChannelID, AccessHash: r.input.AccessHash},
+		Q:         pagination.Search,
+		Filter:    &tg.InputMessagesFilterEmpty{},
+		OffsetID:  offsetID,
+		AddOffset: addOffset,
+		Limit:     pagination.PerPage,
+	})
+	if err != nil {
+		r.handleWorkerError(err)
+		r.logger.Error("failed to search channel", zap.Error(err))
+		return nil, err
+	}
+
+	res, ok := result.(*tg.MessagesChannelMessages)
 	if !ok {
-		r.logger.Error("invalid channel type in PeerStorage")
-		return nil, errors.New("invalid channel type")
+		return nil, errors.New("unexpected response type from Telegram API")
 	}
 
-	req := &tg.ChannelsGetMessagesRequest{
-		Channel: &tg.InputChannel{
-			ChannelID:  inputChannel.ChannelID,
-			AccessHash: inputChannel.AccessHash,
-		},
-		ID: []tg.InputMessageClass{
-			&tg.InputMessageID{ID: messageID},
-			&tg.InputMessageID{ID: messageID + 1},
-		},
+	hits, err := r.hitPosts(ctx, res.Messages)
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := r.client.API().ChannelsGetMessages(ctx, req)
+	var posts []models.Post
+	found := make(map[int]bool)
+	oldest := 0
+	for _, msg := range res.Messages {
+		hit, ok := msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+		if oldest == 0 || hit.ID < oldest {
+			oldest = hit.ID
+		}
+
+		post := hits[hit.ID]
+		if post == nil || found[post.MessageID] {
+			continue
+		}
+		found[post.MessageID] = true
+		posts = append(posts, *post)
+
+		// the next page starts below the whole post, so that it isn't
+		// found again through its other messages
+		oldest = min(oldest, post.MessageID)
+		for _, file := range post.Files {
+			oldest = min(oldest, file.MessageID)
+		}
+	}
+
+	// several messages of a post may match, so the count of messages found
+	// is only an estimate of the posts
+	pagination.Total = res.Count
+	if len(posts) > 0 {
+		pagination.FirstOffsetId = posts[0].MessageID
+		pagination.LastOffsetId = posts[len(posts)-1].MessageID
+	}
+	if len(res.Messages) == pagination.PerPage && oldest > 0 {
+		pagination.NextCursor = models.Cursor{ID: oldest}.Encode()
+	}
+
+	return &models.PaginatedPosts{
+		Data:       posts,
+		Pagination: pagination,
+	}, nil
+}
+
+func (r *Repository) GetPost(ctx context.Context, messageID int) (*models.Post, error) {
+	if post := indexedPost(ctx, r.logger, r.channel.ID, messageID); post != nil {
+		return post, nil
+	}
+
+	// the post is the same whichever account fetched it, so it's cached
+	// once for all workers
+	if post, err := cachedPost(r.logger, r.channel, messageID); post != nil || err != nil {
+		return post, err
+	}
+
+	return r.fetchPost(ctx, messageID)
+}
+
+// fetchPost fetches the post of messageID from the channel and caches it,
+// or caches that there's none.
+func (r *Repository) fetchPost(ctx context.Context, messageID int) (*models.Post, error) {
+	messages, err := r.GetPostMessages(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	post := createPostFromMessages(r.channel, messages)
+	if post == nil || post.MessageID != messageID {
+		if err := cacheMissingPost(r.channel.ID, messageID); err != nil {
+			r.logger.Error("failed to cache missing post", zap.Error(err))
+		}
+		return nil, ErrPostNotFound
+	}
+
+	err = cachePost(r.channel.ID, post)
+	if err != nil {
+		r.logger.Error("failed to cache post", zap.Error(err))
+	}
+
+	return post, nil
+}
+
+// GetDocumentPost returns the post a document message belongs to.
+func (r *Repository) GetDocumentPost(ctx context.Context, messageID int) (*models.Post, error) {
+	post, err := r.messagePost(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if post.File(messageID) == nil {
+		return nil, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// hitPosts returns the posts the messages found by a search are part of,
+// by message ID. The messages around the hits that are neither indexed nor
+// cached are fetched together, rather than a call for each hit.
+func (r *Repository) hitPosts(ctx context.Context, hits []tg.MessageClass) (map[int]*models.Post, error) {
+	posts := make(map[int]*models.Post)
+	var missing, ids []int
+	for _, msg := range hits {
+		hit, ok := msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+
+		post, err := r.storedMessagePost(ctx, hit.ID)
+		switch {
+		case errors.Is(err, ErrPostNotFound):
+		case err != nil:
+			return nil, err
+		case post != nil:
+			posts[hit.ID] = post
+		default:
+			missing = append(missing, hit.ID)
+			ids = append(ids, postWindow(hit.ID)...)
+		}
+	}
+	if len(missing) == 0 {
+		return posts, nil
+	}
+
+	slices.Sort(ids)
+	messages, err := r.getMessages(ctx, slices.Compact(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, messageID := range missing {
+		window := postWindow(messageID)
+		around := slices.DeleteFunc(slices.Clone(messages), func(msg *tg.Message) bool {
+			return msg.ID < window[0] || msg.ID > window[len(window)-1]
+		})
+
+		post := createPostFromMessages(r.channel, postContaining(assemblePosts(around), messageID))
+		r.cacheMessagePost(messageID, post)
+		if post != nil {
+			posts[messageID] = post
+		}
+	}
+	return posts, nil
+}
+
+// messagePost returns the post messageID is part of, from the index or the
+// cache when it's in either. Downloads and searches ask for it on every
+// request, so whether the message is part of a post is cached either way.
+func (r *Repository) messagePost(ctx context.Context, messageID int) (*models.Post, error) {
+	if post, err := r.storedMessagePost(ctx, messageID); post != nil || err != nil {
+		return post, err
+	}
+
+	messages, err := r.GetPostMessages(ctx, messageID)
 	if err != nil {
-		r.logger.Error("failed to fetch message from channel", zap.Error(err))
 		return nil, err
 	}
 
+	post := createPostFromMessages(r.channel, messages)
+	r.cacheMessagePost(messageID, post)
+	if post == nil {
+		return nil, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// storedMessagePost returns the post messageID is part of from the index or
+// the cache, or ErrPostNotFound when it's cached not to be part of one. It
+// returns nil when it's in neither.
+func (r *Repository) storedMessagePost(ctx context.Context, messageID int) (*models.Post, error) {
+	if idx := index.GetIndex(); idx != nil {
+		posts, err := idx.PostsWithMessages(ctx, r.channel.ID, []int{messageID})
+		if err != nil {
+			r.logger.Error("failed to read post from index", zap.Error(err))
+		} else if len(posts) > 0 {
+			setMediaURLs(&posts[0])
+			return &posts[0], nil
+		}
+	}
+
+	var entry postEntry
+	if cache.Get(cache.GetCache(), cacheKey("message_post", r.channel.ID, messageID), &entry) != nil {
+		return nil, nil
+	}
+	if entry.Post == nil {
+		return nil, ErrPostNotFound
+	}
+	setMediaURLs(entry.Post)
+	return entry.Post, nil
+}
+
+// cacheMessagePost caches post as the one messageID is part of, or, when
+// it's nil, that messageID isn't part of a post.
+func (r *Repository) cacheMessagePost(messageID int, post *models.Post) {
+	key := cacheKey("message_post", r.channel.ID, messageID)
+	var err error
+	switch ttl := config.ValueOf.NegativeCacheTTL; {
+	case post != nil:
+		err = cache.Set(cache.GetCache(), key, &postEntry{Post: post, StaleAt: time.Now().Add(cacheTTL)}, cacheTTL)
+	case ttl > 0:
+		err = cache.Set(cache.GetCache(), key, &postEntry{StaleAt: time.Now().Add(ttl)}, ttl)
+	}
+	if err != nil {
+		r.logger.Error("failed to cache message post", zap.Error(err))
+	}
+}
+
+// GetPostMessages returns the messages of the post messageID belongs to,
+// oldest first, or none when it isn't part of one. Concurrent lookups of
+// the same message share one call.
+func (r *Repository) GetPostMessages(ctx context.Context, messageID int) ([]*tg.Message, error) {
+	return coalesceChannel(ctx, r, cacheKey("messages", r.channel.ID, messageID), func(ctx context.Context, r *Repository) ([]*tg.Message, error) {
+		return r.fetchPostMessages(ctx, messageID)
+	})
+}
+
+// fetchPostMessages fetches the messages of the post messageID belongs to.
+// Posts are put together from at most maxAlbumSize messages, so they're all
+// found around any one of them.
+func (r *Repository) fetchPostMessages(ctx context.Context, messageID int) ([]*tg.Message, error) {
+	messages, err := r.getMessages(ctx, postWindow(messageID))
+	if err != nil {
+		return nil, err
+	}
+	return postContaining(assemblePosts(messages), messageID), nil
+}
+
+// postWindow returns the IDs of the messages a post messageID is part of
+// may be put together from.
+func postWindow(messageID int) []int {
+	var ids []int
+	for id := max(messageID-maxAlbumSize+1, 1); id < messageID+maxAlbumSize; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// getMessages fetches the messages ids of the channel, oldest first,
+// leaving out those that don't exist.
+func (r *Repository) getMessages(ctx context.Context, ids []int) ([]*tg.Message, error) {
 	var messages []*tg.Message
-	switch res := result.(type) {
-	case *tg.MessagesChannelMessages:
+	for chunk := range slices.Chunk(ids, maxGetMessages) {
+		input := make([]tg.InputMessageClass, 0, len(chunk))
+		for _, id := range chunk {
+			input = append(input, &tg.InputMessageID{ID: id})
+		}
+
+		result, err := r.client.API().ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: r.input,
+			ID:      input,
+		})
+		if err != nil {
+			r.handleWorkerError(err)
+			r.logger.Error("failed to fetch messages from channel", zap.Error(err))
+			return nil, err
+		}
+
+		res, ok := result.(*tg.MessagesChannelMessages)
+		if !ok {
+			return nil, errors.New("unexpected response type from Telegram API")
+		}
 		for _, msg := range res.Messages {
 			if m, ok := msg.(*tg.Message); ok {
 				messages = append(messages, m)
 			}
 		}
-	default:
-		return nil, errors.New("unexpected response type from Telegram API")
-	}
-
-	if len(messages) == 0 {
-		return nil, errors.New("message not found")
 	}
 
 	sort.Slice(messages, func(i, j int) bool {
 		return messages[i].ID < messages[j].ID
 	})
+	return messages, nil
+}
 
-	post := createPostFromMessages(messages)
-	if post == nil {
-		return nil, errors.New("failed to create post from messages")
+func (r *Repository) GetPostImage(ctx context.Context, messageID int, output io.Writer) error {
+	photo, err := r.GetPhoto(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	chunks := cache.GetChunkCache()
+	key := cache.ChunkKey{Kind: cache.ChunkPhoto, FileID: photo.ID}
+	if data, ok := chunks.Get(key); ok {
+		r.logger.Sugar().Debugf("serving image of message %d from the chunk cache", messageID)
+		_, err := output.Write(data)
+		return err
+	}
+
+	// images are small, so they're kept whole as they're sent and cached
+	// as a single chunk once complete
+	var image bytes.Buffer
+	tee := func(w io.Writer) io.Writer {
+		if chunks == nil {
+			return w
+		}
+		image.Reset()
+		return io.MultiWriter(w, &image)
 	}
 
-	err = cache.GetCache().SetPost(key, post, 3600*12) // 12 hours
+	written := &countingWriter{w: output}
+	err = r.downloadPhoto(ctx, photo, tee(written))
+	if isFileReferenceExpired(err) {
+		// bytes already sent to the client are skipped on the second attempt
+		r.logger.Info("file reference expired, refetching image", zap.Int("message_id", messageID))
+		photo, err = r.RefreshPhoto(ctx, messageID)
+		if err != nil {
+			return err
+		}
+		err = r.downloadPhoto(ctx, photo, tee(&skipWriter{w: written, skip: written.n}))
+	}
 	if err != nil {
-		r.logger.Error("failed to cache post", zap.Error(err))
+		return err
 	}
 
-	return post, nil
+	if err := chunks.Put(key, image.Bytes()); err != nil {
+		r.logger.Warn("failed to cache image", zap.Int("message_id", messageID), zap.Error(err))
+	}
+	return nil
 }
 
-func (r *Repository) GetPostImage(ctx context.Context, messageID int, output io.Writer) error {
-	peerClass := r.client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
-	if peerClass == nil {
-		r.logger.Error("channel not configured in PeerStorage")
-		return errors.New("channel not configured")
+func (r *Repository) downloadPhoto(ctx context.Context, photo *models.Photo, output io.Writer) error {
+	dl := downloader.NewDownloader()
+	_, err := dl.Download(r.client.API(), photo.Location).Stream(ctx, output)
+	if err != nil {
+		r.logger.Error("failed to stream the image", zap.Error(err))
+		return fmt.Errorf("failed to stream the image: %w", err)
 	}
 
-	inputChannel, ok := peerClass.(*tg.InputPeerChannel)
-	if !ok {
-		r.logger.Error("invalid channel type in PeerStorage")
-		return errors.New("invalid channel type")
+	return nil
+}
+
+func (r *Repository) GetPhoto(ctx context.Context, messageID int) (*models.Photo, error) {
+	if mediaMissing("photo", r.channel.ID, messageID) {
+		return nil, ErrPostNotFound
+	}
+
+	var cachedPhoto models.Photo
+	var location tg.InputPhotoFileLocation
+	if cache.Get(cache.GetCache(), cacheKey("photo", r.channel.ID, messageID), &cachedPhoto) == nil &&
+		cache.Get(cache.GetCache(), r.workerCacheKey("photo_location", messageID), &location) == nil {
+		r.logger.Sugar().Infof("using cached photo properties for message %d from user %d", messageID, r.client.Self.ID)
+		cachedPhoto.Location = &location
+		return &cachedPhoto, nil
 	}
 
+	return r.fetchPhoto(ctx, messageID)
+}
+
+// RefreshPhoto refetches the message to obtain a new file reference and
+// replaces the cached photo with it.
+func (r *Repository) RefreshPhoto(ctx context.Context, messageID int) (*models.Photo, error) {
+	r.logger.Info("refreshing photo file reference", zap.Int("message_id", messageID))
+	return r.fetchPhoto(ctx, messageID)
+}
+
+// fetchPhoto fetches the photo of messageID. Concurrent fetches by the same
+// worker share one call, as the location only holds for its account.
+func (r *Repository) fetchPhoto(ctx context.Context, messageID int) (*models.Photo, error) {
+	return coalesceWorker(ctx, r, r.workerCacheKey("photo", messageID), func(ctx context.Context, r *Repository) (*models.Photo, error) {
+		return r.loadPhoto(ctx, messageID)
+	})
+}
+
+func (r *Repository) loadPhoto(ctx context.Context, messageID int) (*models.Photo, error) {
 	req := &tg.ChannelsGetMessagesRequest{
-		Channel: &tg.InputChannel{
-			ChannelID:  inputChannel.ChannelID,
-			AccessHash: inputChannel.AccessHash,
-		},
+		Channel: r.input,
 		ID: []tg.InputMessageClass{
 			&tg.InputMessageID{ID: messageID},
 		},
@@ -260,11 +676,13 @@ func (r *Repository) GetPostImage(ctx context.Context, messageID int, output io.
 
 	result, err := r.client.API().ChannelsGetMessages(ctx, req)
 	if err != nil {
+		r.handleWorkerError(err)
 		r.logger.Error("failed to fetch the message", zap.Error(err))
-		return fmt.Errorf("failed to fetch the message: %w", err)
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
 	}
 
 	var photo *tg.Photo
+	var date int
 	switch msg := result.(type) {
 	case *tg.MessagesChannelMessages:
 		for _, message := range msg.Messages {
@@ -272,6 +690,7 @@ func (r *Repository) GetPostImage(ctx context.Context, messageID int, output io.
 				if media, ok := telegramMsg.Media.(*tg.MessageMediaPhoto); ok && media.Photo != nil {
 					if p, ok := media.Photo.(*tg.Photo); ok {
 						photo = p
+						date = telegramMsg.Date
 						break
 					}
 				}
@@ -280,75 +699,172 @@ func (r *Repository) GetPostImage(ctx context.Context, messageID int, output io.
 	}
 
 	if photo == nil {
-		r.logger.Error("no photo found in the message")
-		return errors.New("no photo found in the message")
+		r.logger.Debug("no photo found in the message", zap.Int("message_id", messageID))
+		if err := cacheMissingMedia("photo", r.channel.ID, messageID); err != nil {
+			r.logger.Error("failed to cache missing photo", zap.Error(err))
+		}
+		return nil, ErrPostNotFound
 	}
 
-	thumbSize := ""
-	if len(photo.Sizes) > 0 {
-		thumbSize = photo.Sizes[len(photo.Sizes)-1].GetType()
-	}
+	photoFile := newPhoto(photo, messageID, date)
 
-	inputLocation := &tg.InputPhotoFileLocation{
-		ID:            photo.ID,
-		AccessHash:    photo.AccessHash,
-		FileReference: photo.FileReference,
-		ThumbSize:     thumbSize,
+	// only the location holds for this account alone, the rest is shared
+	shared := *photoFile
+	shared.Location = nil
+	err = cache.Set(cache.GetCache(), cacheKey("photo", r.channel.ID, messageID), &shared, cacheTTL)
+	if err == nil {
+		err = cache.Set(cache.GetCache(), r.workerCacheKey("photo_location", messageID), photoFile.Location, cacheTTL)
 	}
-
-	dl := downloader.NewDownloader()
-	_, err = dl.Download(r.client.API(), inputLocation).Stream(ctx, output)
 	if err != nil {
-		r.logger.Error("failed to stream the image", zap.Error(err))
-		return fmt.Errorf("failed to stream the image: %w", err)
+		r.logger.Error("failed to cache photo", zap.Error(err))
 	}
 
-	return nil
+	return photoFile, nil
 }
 
-func (r *Repository) GetPostVideo(ctx context.Context, file *models.File, start, end int64) (io.Reader, error) {
+func (r *Repository) GetPostVideo(ctx context.Context, file *models.File, start, end int64) (io.ReadCloser, error) {
 	inputLocation := &tg.InputDocumentFileLocation{
 		ID:            file.Location.ID,
 		AccessHash:    file.Location.AccessHash,
 		FileReference: file.Location.FileReference,
 	}
 
+	// the stream keeps its own hold on the worker until the reader is closed
+	r.worker.acquire()
+	sources := []*chunkSource{r.fileSource(file.MessageID, inputLocation)}
+	sources = append(sources, r.streamSources(ctx, file.MessageID)...)
+
+	// the stream is read after the handler returns, so it only stops once
+	// the reader is closed
 	contentLength := end - start + 1
-	reader, err := NewReader(ctx, r.client, inputLocation, start, end, contentLength)
+	reader, err := NewReader(context.WithoutCancel(ctx), sources, file.ID, r.failoverSource(file.MessageID), start, end, contentLength, config.ValueOf.StreamConcurrency)
 	if err != nil {
 		r.logger.Error("failed to create telegram reader", zap.Error(err))
+		for _, source := range sources {
+			source.worker.Release()
+		}
 		return nil, err
 	}
 
 	return reader, nil
 }
 
+// streamSources resolves the document of messageID through the additional
+// workers configured by STREAM_WORKERS, skipping any worker that can't see it.
+func (r *Repository) streamSources(ctx context.Context, messageID int) []*chunkSource {
+	var sources []*chunkSource
+	exclude := []int64{r.client.Self.ID}
+	for len(sources) < config.ValueOf.StreamWorkers-1 {
+		worker, err := Workers.Acquire(exclude...)
+		if err != nil {
+			break
+		}
+		exclude = append(exclude, worker.Self.ID)
+
+		source, err := r.workerSource(ctx, worker, messageID)
+		if err != nil {
+			r.logger.Warn("worker can't access file, skipping", zap.Int("worker_id", worker.Id), zap.Error(err))
+			worker.Release()
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// failoverSource returns the function a Reader uses to move a stream to
+// another worker, once the one it was using becomes unavailable.
+func (r *Repository) failoverSource(messageID int) func(context.Context, []int64) (*chunkSource, error) {
+	return func(ctx context.Context, exclude []int64) (*chunkSource, error) {
+		exclude = slices.Clone(exclude)
+		for {
+			worker, err := Workers.Acquire(exclude...)
+			if err != nil {
+				return nil, errors.New("no worker available to take over the stream")
+			}
+
+			source, err := r.workerSource(ctx, worker, messageID)
+			if err != nil {
+				r.logger.Warn("worker can't take over stream", zap.Int("worker_id", worker.Id), zap.Error(err))
+				worker.Release()
+				exclude = append(exclude, worker.Self.ID)
+				continue
+			}
+
+			r.logger.Info("stream handed off to worker", zap.Int("worker_id", worker.Id), zap.Int("message_id", messageID))
+			return source, nil
+		}
+	}
+}
+
+// workerSource resolves the channel and the document of messageID through
+// the account of worker, since access hashes and file references are
+// specific to each account. The source takes over the caller's hold on worker.
+func (r *Repository) workerSource(ctx context.Context, worker *Worker, messageID int) (*chunkSource, error) {
+	repo, err := newWorkerRepository(ctx, r.logger, worker, r.channel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve channel: %w", err)
+	}
+
+	file, err := repo.GetFile(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.fileSource(messageID, file.Location), nil
+}
+
+// fileSource returns a chunk source for the document of messageID that
+// refetches the message whenever its file reference expires.
+func (r *Repository) fileSource(messageID int, location *tg.InputDocumentFileLocation) *chunkSource {
+	return &chunkSource{
+		worker:   r.worker,
+		location: location,
+		refresh: func(ctx context.Context) (*tg.InputDocumentFileLocation, error) {
+			file, err := r.RefreshFile(ctx, messageID)
+			if err != nil {
+				return nil, err
+			}
+			return file.Location, nil
+		},
+	}
+}
+
 func (r *Repository) GetFile(ctx context.Context, messageID int) (*models.File, error) {
-	key := fmt.Sprintf("file:%d:%d", messageID, r.client.Self.ID)
+	if mediaMissing("file", r.channel.ID, messageID) {
+		return nil, ErrPostNotFound
+	}
+
 	var cachedFile models.File
-	err := cache.GetCache().GetFile(key, &cachedFile)
-	if err == nil {
+	var location tg.InputDocumentFileLocation
+	if cache.Get(cache.GetCache(), cacheKey("file", r.channel.ID, messageID), &cachedFile) == nil &&
+		cache.Get(cache.GetCache(), r.workerCacheKey("file_location", messageID), &location) == nil {
 		r.logger.Sugar().Infof("using cached media message properties for message %d from user %d", messageID, r.client.Self.ID)
+		cachedFile.Location = &location
 		return &cachedFile, nil
 	}
 
-	peerClass := r.client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
-	if peerClass == nil {
-		r.logger.Error("channel not configured in PeerStorage")
-		return nil, errors.New("channel not configured")
-	}
+	return r.fetchFile(ctx, messageID)
+}
 
-	inputChannel, ok := peerClass.(*tg.InputPeerChannel)
-	if !ok {
-		r.logger.Error("invalid channel type in PeerStorage")
-		return nil, errors.New("invalid channel type")
-	}
+// RefreshFile refetches the message to obtain a new file reference and
+// replaces the cached file with it.
+func (r *Repository) RefreshFile(ctx context.Context, messageID int) (*models.File, error) {
+	r.logger.Info("refreshing file reference", zap.Int("message_id", messageID))
+	return r.fetchFile(ctx, messageID)
+}
+
+// fetchFile fetches the document of messageID. Concurrent fetches by the
+// same worker share one call, as the location only holds for its account.
+func (r *Repository) fetchFile(ctx context.Context, messageID int) (*models.File, error) {
+	return coalesceWorker(ctx, r, r.workerCacheKey("file", messageID), func(ctx context.Context, r *Repository) (*models.File, error) {
+		return r.loadFile(ctx, messageID)
+	})
+}
 
+func (r *Repository) loadFile(ctx context.Context, messageID int) (*models.File, error) {
 	req := &tg.ChannelsGetMessagesRequest{
-		Channel: &tg.InputChannel{
-			ChannelID:  inputChannel.ChannelID,
-			AccessHash: inputChannel.AccessHash,
-		},
+		Channel: r.input,
 		ID: []tg.InputMessageClass{
 			&tg.InputMessageID{ID: messageID},
 		},
@@ -356,33 +872,45 @@ func (r *Repository) GetFile(ctx context.Context, messageID int) (*models.File,
 
 	result, err := r.client.API().ChannelsGetMessages(ctx, req)
 	if err != nil {
+		r.handleWorkerError(err)
 		r.logger.Error("failed to fetch the message", zap.Error(err))
 		return nil, fmt.Errorf("failed to fetch the message: %w", err)
 
 	}
 
-	messages := result.(*tg.MessagesChannelMessages)
-	message := messages.Messages[0].(*tg.Message)
-	media := message.Media.(*tg.MessageMediaDocument)
-	document, _ := media.Document.AsNotEmpty()
-
-	var fileName string
-	for _, attribute := range document.Attributes {
-		if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-			fileName = name.FileName
-			break
+	var document *tg.Document
+	var date int
+	if msg, ok := result.(*tg.MessagesChannelMessages); ok {
+		for _, message := range msg.Messages {
+			if telegramMsg, ok := message.(*tg.Message); ok && telegramMsg.Media != nil {
+				if media, ok := telegramMsg.Media.(*tg.MessageMediaDocument); ok && media.Document != nil {
+					if d, ok := media.Document.AsNotEmpty(); ok {
+						document = d
+						date = telegramMsg.Date
+						break
+					}
+				}
+			}
 		}
 	}
 
-	file := &models.File{
-		Location: &tg.InputDocumentFileLocation{ID: document.ID, AccessHash: document.AccessHash, FileReference: document.FileReference},
-		FileSize: document.Size,
-		FileName: fileName,
-		MimeType: document.MimeType,
-		ID:       document.ID,
+	if document == nil {
+		r.logger.Debug("no document found in the message", zap.Int("message_id", messageID))
+		if err := cacheMissingMedia("file", r.channel.ID, messageID); err != nil {
+			r.logger.Error("failed to cache missing file", zap.Error(err))
+		}
+		return nil, ErrPostNotFound
 	}
 
-	err = cache.GetCache().SetFile(key, file, 3600*12) // 12 hours
+	file := newFile(document, messageID, date)
+
+	// only the location holds for this account alone, the rest is shared
+	shared := *file
+	shared.Location = nil
+	err = cache.Set(cache.GetCache(), cacheKey("file", r.channel.ID, messageID), &shared, cacheTTL)
+	if err == nil {
+		err = cache.Set(cache.GetCache(), r.workerCacheKey("file_location", messageID), file.Location, cacheTTL)
+	}
 	if err != nil {
 		r.logger.Error("failed to cache file", zap.Error(err))
 	}
@@ -396,19 +924,12 @@ func (r *Repository) GetFileHash(ctx context.Context, messageID int) (string, er
 		return "", err
 	}
 
-	hash := &models.HashFileStruct{
-		FileName: file.FileName,
-		FileSize: file.FileSize,
-		MimeType: file.MimeType,
-		FileID:   file.ID,
-	}
-
-	return hash.Pack(), nil
+	return file.Hash(), nil
 }
 
 func (r *Repository) RefreshAccessHash(ctx context.Context) error {
 	inputChannel := &tg.InputChannel{
-		ChannelID: config.ValueOf.ChannelId,
+		ChannelID: r.channel.ID,
 	}
 
 	channels, err := r.client.API().ChannelsGetChannels(ctx, []tg.InputChannelClass{inputChannel})
@@ -431,61 +952,63 @@ func (r *Repository) RefreshAccessHash(ctx context.Context) error {
 	return nil
 }
 
-func limitGroupsByMostRecent(groups map[int64][]*tg.Message, needed int) map[int64][]*tg.Message {
-	type groupInfo struct {
-		groupID  int64
-		msgs     []*tg.Message
-		maxMsgID int
-	}
+// cacheKey names what's cached of kind for messageID of channelID, which
+// is the same for every worker.
+func cacheKey(kind string, channelID int64, messageID int) string {
+	return fmt.Sprintf("%s:%d:%d", kind, channelID, messageID)
+}
 
-	var groupsSlice []groupInfo
-	for gID, msgs := range groups {
-		maxID := msgs[0].ID
-		for _, m := range msgs {
-			if m.ID > maxID {
-				maxID = m.ID
-			}
-		}
-		groupsSlice = append(groupsSlice, groupInfo{
-			groupID:  gID,
-			msgs:     msgs,
-			maxMsgID: maxID,
-		})
-	}
+// workerCacheKey names what's cached of kind for messageID that only holds
+// for the account of the worker, such as file references.
+func (r *Repository) workerCacheKey(kind string, messageID int) string {
+	return workerCacheKey(kind, r.channel.ID, messageID, r.client.Self.ID)
+}
+
+func workerCacheKey(kind string, channelID int64, messageID int, userID int64) string {
+	return fmt.Sprintf("%s:%d:%d:%d", kind, channelID, messageID, userID)
+}
 
-	sort.Slice(groupsSlice, func(i, j int) bool {
-		return groupsSlice[i].maxMsgID > groupsSlice[j].maxMsgID
+// completeGroups puts messages together into posts and returns those that
+// can't have messages older than oldest, newest first. With exhausted every
+// post is complete.
+func completeGroups(messages []*tg.Message, oldest int, exhausted bool) [][]*tg.Message {
+	sorted := slices.Clone(messages)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
 	})
 
-	if len(groupsSlice) > needed {
-		groupsSlice = groupsSlice[:needed]
+	var complete [][]*tg.Message
+	for _, post := range assemblePosts(sorted) {
+		if exhausted || post[0].ID > oldest {
+			complete = append(complete, post)
+		}
 	}
 
-	limited := make(map[int64][]*tg.Message, needed)
-	for _, gi := range groupsSlice {
-		limited[gi.groupID] = gi.msgs
-	}
-	return limited
+	slices.Reverse(complete)
+	return complete
 }
 
-func createPostFromMessages(messages []*tg.Message) *models.Post {
+// createPostFromMessages builds the post of channel put together from
+// messages, reading its caption with the parser profile of the channel.
+func createPostFromMessages(channel *models.Channel, messages []*tg.Message) *models.Post {
 	var info *tg.Message
-	var media *tg.Message
+	var files []models.PostFile
 
 	for _, msg := range messages {
 		if msg.Message != "" && info == nil {
 			info = msg
 		}
-		if _, isDoc := msg.Media.(*tg.MessageMediaDocument); isDoc && media == nil {
-			media = msg
+		if file, ok := newPostFile(msg); ok {
+			files = append(files, file)
 		}
 	}
 
 	if info != nil {
-		parsedContent := utils.ParseMessageContent(info.Message)
+		parsedContent := utils.ParseMessageWithProfile(channel.Profile, info.Message)
 
 		post := &models.Post{
-			ImageURL:        GetImageURL(info.ID),
+			Channel:         channel.Slug,
+			ChannelID:       channel.ID,
 			MessageID:       info.ID,
 			GroupedID:       info.GroupedID,
 			Date:            info.Date,
@@ -493,27 +1016,121 @@ func createPostFromMessages(messages []*tg.Message) *models.Post {
 			OriginalContent: info.Message,
 			Reactions:       extractReactions(info.Reactions),
 			ParsedContent:   parsedContent,
+			Files:           files,
 		}
 
-		if media != nil {
-			if document, ok := media.Media.(*tg.MessageMediaDocument); ok {
-				if document.Document != nil {
-					if doc, ok := document.Document.AsNotEmpty(); ok {
-						post.DocumentID = doc.ID
-						post.DocumentSize = doc.Size
-					}
-					post.DocumentMessageID = media.ID
-					post.VideoURL = GetVideoURL(media.ID)
-				}
+		if media, ok := info.Media.(*tg.MessageMediaPhoto); ok && media.Photo != nil {
+			if photo, ok := media.Photo.(*tg.Photo); ok {
+				post.ImageHash = newPhoto(photo, info.ID, info.Date).Hash()
 			}
 		}
 
+		if len(files) > 0 {
+			post.DocumentID = files[0].DocumentID
+			post.DocumentSize = files[0].Size
+			post.DocumentHash = files[0].Hash
+			post.DocumentMessageID = files[0].MessageID
+		}
+
+		setMediaURLs(post)
 		return post
 	}
 
 	return nil
 }
 
+// newPostFile describes the document of msg, if it has one.
+func newPostFile(msg *tg.Message) (models.PostFile, bool) {
+	media, ok := msg.Media.(*tg.MessageMediaDocument)
+	if !ok || media.Document == nil {
+		return models.PostFile{}, false
+	}
+
+	file := models.PostFile{MessageID: msg.ID}
+	doc, ok := media.Document.AsNotEmpty()
+	if !ok {
+		return file, true
+	}
+
+	f := newFile(doc, msg.ID, msg.Date)
+	file.DocumentID = doc.ID
+	file.Size = doc.Size
+	file.MimeType = doc.MimeType
+	file.FileName = f.FileName
+	file.Hash = f.Hash()
+	file.FileInfo = utils.ParseFileName(f.FileName)
+
+	// videos sent without a name still tell their resolution
+	if file.Quality == "" {
+		for _, attribute := range doc.Attributes {
+			if video, ok := attribute.(*tg.DocumentAttributeVideo); ok && video.H > 0 {
+				file.Quality = fmt.Sprintf("%dp", video.H)
+				break
+			}
+		}
+	}
+	return file, true
+}
+
+func newFile(document *tg.Document, messageID int, date int) *models.File {
+	var fileName string
+	for _, attribute := range document.Attributes {
+		if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
+			fileName = name.FileName
+			break
+		}
+	}
+
+	return &models.File{
+		Location:  &tg.InputDocumentFileLocation{ID: document.ID, AccessHash: document.AccessHash, FileReference: document.FileReference},
+		FileSize:  document.Size,
+		FileName:  fileName,
+		MimeType:  document.MimeType,
+		ID:        document.ID,
+		MessageID: messageID,
+		Date:      date,
+	}
+}
+
+func newPhoto(photo *tg.Photo, messageID int, date int) *models.Photo {
+	thumbSize := ""
+	var size int64
+	if len(photo.Sizes) > 0 {
+		largest := photo.Sizes[len(photo.Sizes)-1]
+		thumbSize = largest.GetType()
+		size = photoSize(largest)
+	}
+
+	return &models.Photo{
+		Location: &tg.InputPhotoFileLocation{
+			ID:            photo.ID,
+			AccessHash:    photo.AccessHash,
+			FileReference: photo.FileReference,
+			ThumbSize:     thumbSize,
+		},
+		FileSize:  size,
+		MimeType:  "image/jpeg",
+		ID:        photo.ID,
+		MessageID: messageID,
+		Date:      date,
+	}
+}
+
+// photoSize returns the size in bytes of a photo size, or 0 when unknown.
+func photoSize(size tg.PhotoSizeClass) int64 {
+	switch s := size.(type) {
+	case *tg.PhotoSize:
+		return int64(s.Size)
+	case *tg.PhotoSizeProgressive:
+		if len(s.Sizes) > 0 {
+			return int64(s.Sizes[len(s.Sizes)-1])
+		}
+	case *tg.PhotoCachedSize:
+		return int64(len(s.Bytes))
+	}
+	return 0
+}
+
 func extractReactions(reactions tg.MessageReactions) []models.Reaction {
 	var extractedReactions []models.Reaction
 	if len(reactions.Results) == 0 {
@@ -531,8 +1148,8 @@ func extractReactions(reactions tg.MessageReactions) []models.Reaction {
 	return extractedReactions
 }
 
-func GetInputChannel(ctx context.Context, client *gotgproto.Client) (*tg.InputChannel, error) {
-	peerClass := client.PeerStorage.GetInputPeerById(config.ValueOf.ChannelId)
+func GetInputChannel(ctx context.Context, client *gotgproto.Client, channelID int64) (*tg.InputChannel, error) {
+	peerClass := client.PeerStorage.GetInputPeerById(channelID)
 
 	switch peer := peerClass.(type) {
 	case *tg.InputPeerEmpty:
@@ -547,7 +1164,7 @@ func GetInputChannel(ctx context.Context, client *gotgproto.Client) (*tg.InputCh
 	}
 
 	inputChannel := &tg.InputChannel{
-		ChannelID: config.ValueOf.ChannelId,
+		ChannelID: channelID,
 	}
 	channels, err := client.API().ChannelsGetChannels(ctx, []tg.InputChannelClass{inputChannel})
 	if err != nil {
@@ -565,25 +1182,93 @@ func GetInputChannel(ctx context.Context, client *gotgproto.Client) (*tg.InputCh
 	return channel.AsInput(), nil
 }
 
-func GetImageURL(messageID int) string {
-	return fmt.Sprintf(config.ValueOf.Host+"/api/v1/posts/images/%d", messageID)
+func isChannelInvalidError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "CHANNEL_INVALID")
 }
 
-func GetVideoURL(messageID int) string {
-	return fmt.Sprintf(config.ValueOf.Host+"/api/v1/posts/videos/%d", messageID)
+// handleWorkerError records err against the worker health and makes the
+// worker resolve the channel again on its next request when Telegram rejects
+// the access hash it has been using.
+func (r *Repository) handleWorkerError(err error) {
+	r.worker.reportFailure(err)
+	if isChannelInvalidError(err) {
+		r.worker.ResetInputChannel(r.channel.ID)
+	}
 }
 
-func isChannelInvalidError(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "CHANNEL_INVALID")
+func isFileReferenceExpired(err error) bool {
+	return tgerr.Is(err, "FILE_REFERENCE_EXPIRED", "FILE_REFERENCE_INVALID")
 }
 
-func refreshAccessHash(ctx context.Context, client *gotgproto.Client, logger *zap.Logger) error {
-	logger.Info("Refreshing AccessHash...")
-	repo := &Repository{client: client, logger: logger}
-	if err := repo.RefreshAccessHash(ctx); err != nil {
-		logger.Error("Failed to refresh AccessHash", zap.Error(err))
-		return err
+// isWorkerUnavailable reports whether err means the worker itself can't serve
+// requests right now (flood wait, dead session, a stalled or closed
+// connection), as opposed to the request being wrong or going away. Only
+// those are held against the worker and failed over.
+func isWorkerUnavailable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrPostNotFound),
+		errors.Is(err, errRefreshFailed):
+		return false
+	case errors.Is(err, errChunkTimeout),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed),
+		errors.Is(err, pool.ErrConnDead),
+		errors.Is(err, rpc.ErrEngineClosed):
+		return true
 	}
-	logger.Info("AccessHash refreshed successfully")
-	return nil
+
+	if _, ok := tgerr.AsFloodWait(err); ok {
+		return true
+	}
+	if _, ok := tgerr.As(err); ok {
+		return tgerr.Is(err,
+			"AUTH_KEY_UNREGISTERED",
+			"AUTH_KEY_INVALID",
+			"SESSION_REVOKED",
+			"SESSION_EXPIRED",
+			"USER_DEACTIVATED",
+			"USER_DEACTIVATED_BAN",
+		)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// skipWriter discards the first skip bytes written through it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	if s.skip > 0 {
+		if int64(len(p)) <= s.skip {
+			s.skip -= int64(len(p))
+			return total, nil
+		}
+		p = p[s.skip:]
+		s.skip = 0
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return total, nil
 }
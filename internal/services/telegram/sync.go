@@ -0,0 +1,276 @@
+package telegram
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go-winx-api/internal/cache"
+	"go-winx-api/internal/index"
+	"go-winx-api/internal/models"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+const (
+	// syncDelay gives the rest of an album time to arrive before it's
+	// fetched, since every message of an album comes as its own update.
+	syncDelay   = 2 * time.Second
+	syncTimeout = 30 * time.Second
+)
+
+// channelSync keeps the index and the cache in step with the channels as
+// posts are published, edited, deleted or reacted to.
+type channelSync struct {
+	log *zap.Logger
+
+	mu      sync.Mutex
+	pending map[syncKey]bool
+}
+
+// syncKey is the album, or the message outside of one, waiting to be
+// resynced.
+type syncKey struct {
+	channelID int64
+	groupedID int64
+	messageID int
+}
+
+// StartSync subscribes the client to the updates of the channels.
+func StartSync(client *gotgproto.Client, log *zap.Logger) {
+	s := &channelSync{
+		log:     log.Named("sync"),
+		pending: make(map[syncKey]bool),
+	}
+	client.Dispatcher.AddHandler(handlers.NewAnyUpdate(s.handle))
+	for _, channel := range Channels() {
+		s.log.Sugar().Infof("listening for updates of channel %s (%d)", channel.Slug, channel.ID)
+	}
+}
+
+func (s *channelSync) handle(_ *ext.Context, u *ext.Update) error {
+	switch update := u.UpdateClass.(type) {
+	case *tg.UpdateNewChannelMessage:
+		s.messageChanged(update.Message)
+	case *tg.UpdateEditChannelMessage:
+		s.messageChanged(update.Message)
+	case *tg.UpdateDeleteChannelMessages:
+		if channel := channelByID(update.ChannelID); channel != nil {
+			go s.messagesDeleted(channel, update.Messages)
+		}
+	case *tg.UpdateMessageReactions:
+		if channel := peerChannel(update.Peer); channel != nil {
+			go s.reactionsChanged(channel, update.MsgID, update.Reactions)
+		}
+	}
+	return nil
+}
+
+func (s *channelSync) messageChanged(message tg.MessageClass) {
+	msg, ok := message.(*tg.Message)
+	if !ok {
+		return
+	}
+	if channel := peerChannel(msg.PeerID); channel != nil {
+		s.schedule(channel, msg.ID, msg.GroupedID)
+	}
+}
+
+// schedule resyncs the post of messageID after syncDelay, once for all the
+// updates its album receives in the meantime.
+func (s *channelSync) schedule(channel *models.Channel, messageID int, groupedID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := syncKey{channelID: channel.ID, groupedID: groupedID}
+	if groupedID == 0 {
+		key.messageID = messageID
+	}
+	if s.pending[key] {
+		return
+	}
+	s.pending[key] = true
+
+	time.AfterFunc(syncDelay, func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+
+		s.resync(channel, messageID)
+	})
+}
+
+// resync fetches the messages of the post again, parses its caption and
+// replaces the post in the index and the cache.
+func (s *channelSync) resync(channel *models.Channel, messageID int) {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	log := s.log.With(zap.String("channel", channel.Slug))
+	repository, err := NewChannelRepository(ctx, log, channel)
+	if err != nil {
+		log.Error("failed to resync post", zap.Int("message_id", messageID), zap.Error(err))
+		return
+	}
+	defer repository.Close()
+
+	// an edit mustn't be answered by a lookup that started before it
+	messages, err := repository.fetchPostMessages(ctx, messageID)
+	if err != nil {
+		log.Error("failed to resync post", zap.Int("message_id", messageID), zap.Error(err))
+		return
+	}
+
+	ids := []int{messageID}
+	for _, msg := range messages {
+		if msg.ID != messageID {
+			ids = append(ids, msg.ID)
+		}
+	}
+	invalidateCache(channel.ID, ids...)
+
+	post := createPostFromMessages(channel, messages)
+	if post == nil {
+		// an edit may have taken the caption out of a post, or the whole
+		// post may be gone, so whatever was indexed of it is dropped
+		log.Debug("message isn't part of a post", zap.Int("message_id", messageID))
+		s.dropPosts(ctx, channel, ids)
+		return
+	}
+
+	if idx := index.GetIndex(); idx != nil {
+		if err := idx.UpsertPosts(ctx, []models.Post{*post}); err != nil {
+			log.Error("failed to index post", zap.Int("message_id", post.MessageID), zap.Error(err))
+			return
+		}
+	}
+	log.Info("post synced", zap.Int("message_id", post.MessageID))
+}
+
+// dropPosts removes the posts indexed with any of messageIDs, along with
+// what's cached of their files.
+func (s *channelSync) dropPosts(ctx context.Context, channel *models.Channel, messageIDs []int) {
+	idx := index.GetIndex()
+	if idx == nil {
+		return
+	}
+
+	posts, err := idx.PostsWithMessages(ctx, channel.ID, messageIDs)
+	if err != nil {
+		s.log.Error("failed to look up dropped posts", zap.Error(err))
+		return
+	}
+
+	var dropped []int
+	for _, post := range posts {
+		dropped = append(dropped, post.MessageID)
+		invalidateCache(channel.ID, post.MessageID)
+		for _, file := range post.Files {
+			invalidateCache(channel.ID, file.MessageID)
+		}
+	}
+
+	if err := idx.DeletePosts(ctx, channel.ID, dropped...); err != nil {
+		s.log.Error("failed to delete posts from index", zap.Error(err))
+		return
+	}
+	if len(dropped) > 0 {
+		s.log.Info("posts dropped", zap.String("channel", channel.Slug), zap.Ints("message_ids", dropped))
+	}
+}
+
+// messagesDeleted drops the posts whose caption was deleted, and resyncs
+// those that only lost part of their album.
+func (s *channelSync) messagesDeleted(channel *models.Channel, messageIDs []int) {
+	invalidateCache(channel.ID, messageIDs...)
+
+	idx := index.GetIndex()
+	if idx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	posts, err := idx.PostsWithMessages(ctx, channel.ID, messageIDs)
+	if err != nil {
+		s.log.Error("failed to look up deleted posts", zap.Error(err))
+		return
+	}
+
+	var deleted []int
+	for _, post := range posts {
+		if slices.Contains(messageIDs, post.MessageID) {
+			deleted = append(deleted, post.MessageID)
+			for _, file := range post.Files {
+				invalidateCache(channel.ID, file.MessageID)
+			}
+		} else {
+			s.schedule(channel, post.MessageID, post.GroupedID)
+		}
+	}
+
+	if err := idx.DeletePosts(ctx, channel.ID, deleted...); err != nil {
+		s.log.Error("failed to delete posts from index", zap.Error(err))
+		return
+	}
+	if len(deleted) > 0 {
+		s.log.Info("posts deleted", zap.String("channel", channel.Slug), zap.Ints("message_ids", deleted))
+	}
+}
+
+func (s *channelSync) reactionsChanged(channel *models.Channel, messageID int, reactions tg.MessageReactions) {
+	invalidateCache(channel.ID, messageID)
+
+	idx := index.GetIndex()
+	if idx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	post, err := idx.GetPost(ctx, channel.ID, messageID)
+	if err != nil {
+		return
+	}
+
+	post.Reactions = extractReactions(reactions)
+	if err := idx.UpsertPosts(ctx, []models.Post{*post}); err != nil {
+		s.log.Error("failed to update reactions", zap.Int("message_id", messageID), zap.Error(err))
+	}
+}
+
+// invalidateCache drops the post, file and photo cached for messageIDs of
+// the channel, or that there's none, along with the file locations cached
+// by every worker.
+func invalidateCache(channelID int64, messageIDs ...int) {
+	Workers.mut.Lock()
+	users := append([]*Worker(nil), Workers.Users...)
+	Workers.mut.Unlock()
+
+	for _, id := range messageIDs {
+		for _, kind := range []string{"post", "message_post", "file", "photo", "missing_file", "missing_photo"} {
+			_ = cache.GetCache().Delete(cacheKey(kind, channelID, id))
+		}
+		for _, worker := range users {
+			for _, kind := range []string{"file_location", "photo_location"} {
+				_ = cache.GetCache().Delete(workerCacheKey(kind, channelID, id, worker.Self.ID))
+			}
+		}
+	}
+}
+
+// peerChannel returns the configured channel peer is, or nil.
+func peerChannel(peer tg.PeerClass) *models.Channel {
+	channel, ok := peer.(*tg.PeerChannel)
+	if !ok {
+		return nil
+	}
+	return channelByID(channel.ChannelID)
+}
@@ -2,10 +2,12 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"go-winx-api/config"
 
@@ -16,21 +18,91 @@ import (
 	"go.uber.org/zap"
 )
 
+// Strategy decides which worker the pool hands out next.
+type Strategy string
+
+const (
+	StrategyRoundRobin Strategy = "round_robin"
+	StrategyLeastBusy  Strategy = "least_busy"
+)
+
+var ErrNoWorkers = errors.New("no worker available")
+
+// ErrChannelUnavailable is returned when none of the workers could access a
+// channel.
+var ErrChannelUnavailable = errors.New("channel unavailable")
+
 type Worker struct {
 	Id     int
 	Client *gotgproto.Client
 	Self   *tg.User
 	log    *zap.Logger
+
+	inFlight  atomic.Int64
+	health    workerHealth
+	channelMu sync.Mutex
+	channels  map[int64]*tg.InputChannel
 }
 
 func (w *Worker) String() string {
 	return fmt.Sprintf("{Worker (%d|@%s)}", w.Id, w.Self.Username)
 }
 
+// InFlight returns the number of requests and streams currently using the worker.
+func (w *Worker) InFlight() int64 {
+	return w.inFlight.Load()
+}
+
+func (w *Worker) acquire() {
+	w.inFlight.Add(1)
+}
+
+// Release hands a worker obtained from Acquire back to the pool.
+func (w *Worker) Release() {
+	w.inFlight.Add(-1)
+}
+
+// InputChannel returns the channel channelID as seen by this worker's
+// account. The access hash is refreshed once and then reused until
+// ResetInputChannel is called.
+func (w *Worker) InputChannel(ctx context.Context, channelID int64) (*tg.InputChannel, error) {
+	w.channelMu.Lock()
+	defer w.channelMu.Unlock()
+
+	if channel, ok := w.channels[channelID]; ok {
+		return channel, nil
+	}
+
+	if err := w.EnsureValidAccessHash(ctx, channelID); err != nil {
+		return nil, err
+	}
+
+	channel, err := GetInputChannel(ctx, w.Client, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.channels == nil {
+		w.channels = make(map[int64]*tg.InputChannel)
+	}
+	w.channels[channelID] = channel
+	return channel, nil
+}
+
+// ResetInputChannel forgets the resolved channel channelID, e.g. after
+// Telegram reports its access hash as invalid.
+func (w *Worker) ResetInputChannel(channelID int64) {
+	w.channelMu.Lock()
+	defer w.channelMu.Unlock()
+	delete(w.channels, channelID)
+}
+
 type UserWorkers struct {
 	Users    []*Worker
 	starting int
 	index    int
+	strategy Strategy
+	pending  atomic.Int32
 	mut      sync.Mutex
 	log      *zap.Logger
 }
@@ -49,10 +121,12 @@ func (w *UserWorkers) AddDefaultClient(client *gotgproto.Client, self *tg.User)
 		w.Users = make([]*Worker, 0)
 	}
 
-	w.incStarting()
+	id := w.nextID()
+	w.mut.Lock()
+	defer w.mut.Unlock()
 	w.Users = append(w.Users, &Worker{
 		Client: client,
-		Id:     w.starting,
+		Id:     id,
 		Self:   self,
 		log:    w.log,
 	})
@@ -60,13 +134,19 @@ func (w *UserWorkers) AddDefaultClient(client *gotgproto.Client, self *tg.User)
 }
 
 func (w *UserWorkers) Add(token string) (err error) {
-	w.incStarting()
-	var userId int = w.starting
+	return w.start(w.nextID(), token)
+}
+
+// start starts the worker userId for token. The ID is assigned once with
+// nextID, and kept by every retry so the worker keeps its session name.
+func (w *UserWorkers) start(userId int, token string) error {
 	client, err := startWorker(w.log, token, userId)
 	if err != nil {
 		return err
 	}
 	w.log.Sugar().Infof("bot @%s loaded with ID %d", client.Self.Username, userId)
+	w.mut.Lock()
+	defer w.mut.Unlock()
 	w.Users = append(w.Users, &Worker{
 		Client: client,
 		Id:     userId,
@@ -102,29 +182,58 @@ func (w *Worker) EnsureValidAccessHash(ctx context.Context, channelID int64) err
 	return nil
 }
 
-func GetNextWorker() *Worker {
-	Workers.mut.Lock()
-	defer Workers.mut.Unlock()
-	index := (Workers.index + 1) % len(Workers.Users)
-	Workers.index = index
-	worker := Workers.Users[index]
+// Acquire borrows a healthy worker whose account is not in exclude, chosen by
+// the pool strategy. The worker must be handed back with Release.
+func (w *UserWorkers) Acquire(exclude ...int64) (*Worker, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
 
-	err := worker.EnsureValidAccessHash(context.Background(), config.ValueOf.ChannelId)
-	if err != nil {
-		Workers.log.Error("Failed to update access_hash for worker", zap.Int("worker_id", worker.Id), zap.Error(err))
-	} else {
-		Workers.log.Info("Access hash updated successfully", zap.Int("worker_id", worker.Id))
+	var chosen *Worker
+	chosenIndex := 0
+	total := len(w.Users)
+	for i := 1; i <= total; i++ {
+		index := (w.index + i) % total
+		worker := w.Users[index]
+		if slices.Contains(exclude, worker.Self.ID) || !worker.Healthy() {
+			continue
+		}
+		if chosen == nil || worker.InFlight() < chosen.InFlight() {
+			chosen, chosenIndex = worker, index
+		}
+		if w.strategy != StrategyLeastBusy {
+			break
+		}
 	}
 
-	Workers.log.Sugar().Infof("using worker %d", worker.Id)
-	fmt.Printf("using worker %d\n", worker.Id)
-	return worker
+	if chosen == nil {
+		return nil, ErrNoWorkers
+	}
+
+	w.index = chosenIndex
+	chosen.acquire()
+	w.log.Debug("acquired worker", zap.Int("worker_id", chosen.Id), zap.Int64("in_flight", chosen.InFlight()))
+	return chosen, nil
 }
 
 func StartWorkers(log *zap.Logger) (*UserWorkers, error) {
 	Workers.Init(log)
 
-	if len(config.ValueOf.StringSessions) == 0 {
+	switch strategy := Strategy(config.ValueOf.WorkerStrategy); strategy {
+	case StrategyRoundRobin, StrategyLeastBusy:
+		Workers.strategy = strategy
+	default:
+		Workers.log.Sugar().Warnf("unknown WORKER_STRATEGY %q, defaulting to %s", strategy, StrategyLeastBusy)
+		Workers.strategy = StrategyLeastBusy
+	}
+
+	// a blank session can never start, and would be retried forever
+	var sessions []string
+	for _, session := range config.ValueOf.StringSessions {
+		if session = strings.TrimSpace(session); session != "" {
+			sessions = append(sessions, session)
+		}
+	}
+	if len(sessions) == 0 {
 		Workers.log.Sugar().Info("no worker bot tokens provided, skipping worker initialization")
 		return Workers, nil
 	}
@@ -133,19 +242,20 @@ func StartWorkers(log *zap.Logger) (*UserWorkers, error) {
 
 	var wg sync.WaitGroup
 	var successfulStarts int32
-	totalUsers := len(config.ValueOf.StringSessions)
+	totalUsers := len(sessions)
 
 	for i := 0; i < totalUsers; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
+			id := Workers.nextID()
 
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), workerStartTimeout)
 			defer cancel()
 
 			done := make(chan error, 1)
 			go func() {
-				err := Workers.Add(config.ValueOf.StringSessions[i])
+				err := Workers.start(id, sessions[i])
 				done <- err
 			}()
 
@@ -153,11 +263,17 @@ func StartWorkers(log *zap.Logger) (*UserWorkers, error) {
 			case err := <-done:
 				if err != nil {
 					Workers.log.Error("Failed to start worker", zap.Int("index", i), zap.Error(err))
+					go Workers.retryStart(id, sessions[i])
 				} else {
 					atomic.AddInt32(&successfulStarts, 1)
 				}
 			case <-ctx.Done():
 				Workers.log.Error("Timed out starting worker", zap.Int("index", i))
+				go func() {
+					if err := <-done; err != nil {
+						Workers.retryStart(id, sessions[i])
+					}
+				}()
 			}
 		}(i)
 	}
@@ -167,10 +283,12 @@ func StartWorkers(log *zap.Logger) (*UserWorkers, error) {
 	return Workers, nil
 }
 
-func (w *UserWorkers) incStarting() {
+// nextID assigns the ID of a worker being started.
+func (w *UserWorkers) nextID() int {
 	w.mut.Lock()
 	defer w.mut.Unlock()
 	w.starting++
+	return w.starting
 }
 
 func startWorker(l *zap.Logger, ss string, index int) (*gotgproto.Client, error) {
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+var (
+	qualityRegex       = regexp.MustCompile(`(?i)\b(2160p|1440p|1080p|720p|576p|480p|360p|240p|4k|uhd)\b`)
+	seasonEpisodeRegex = regexp.MustCompile(`(?i)\bS(\d{1,2})[\s.-]?E(\d{1,3})(?:\b|E\d)`)
+	crossEpisodeRegex  = regexp.MustCompile(`\b(\d{1,2})x(\d{2,3})\b`)
+	seasonRegex        = regexp.MustCompile(`(?i)\b(?:temporada|season|temp)[\s.-]*(\d{1,2})\b`)
+	episodeRegex       = regexp.MustCompile(`(?i)\b(?:epis[oó]dio|episode|ep)[\s.-]*(\d{1,3})\b`)
+	partRegex          = regexp.MustCompile(`(?i)\b(?:cd|disco|disc|parte|part|pt)[\s.-]*(\d{1,2})\b`)
+	fileNameSeparators = strings.NewReplacer("_", " ", "[", " ", "]", " ", "(", " ", ")", " ")
+	qualityAliases     = map[string]string{"4k": "2160p", "uhd": "2160p"}
+)
+
+// ParseFileName reads the quality, season, episode and part of a release
+// from its file name, such as "Serie.S01E02.1080p.mkv" or "Filme CD2.avi".
+// Files with several episodes, such as "Serie.S01E01E02.mkv", are read as
+// the first of them.
+func ParseFileName(name string) models.FileInfo {
+	var info models.FileInfo
+	name = fileNameSeparators.Replace(name)
+
+	if match := qualityRegex.FindStringSubmatch(name); match != nil {
+		quality := strings.ToLower(match[1])
+		if normalized, ok := qualityAliases[quality]; ok {
+			quality = normalized
+		}
+		info.Quality = quality
+	}
+
+	if match := seasonEpisodeRegex.FindStringSubmatch(name); match != nil {
+		info.Season, _ = strconv.Atoi(match[1])
+		info.Episode, _ = strconv.Atoi(match[2])
+	} else if match := crossEpisodeRegex.FindStringSubmatch(name); match != nil {
+		info.Season, _ = strconv.Atoi(match[1])
+		info.Episode, _ = strconv.Atoi(match[2])
+	} else {
+		if match := seasonRegex.FindStringSubmatch(name); match != nil {
+			info.Season, _ = strconv.Atoi(match[1])
+		}
+		if match := episodeRegex.FindStringSubmatch(name); match != nil {
+			info.Episode, _ = strconv.Atoi(match[1])
+		}
+	}
+
+	if match := partRegex.FindStringSubmatch(name); match != nil {
+		info.Part, _ = strconv.Atoi(match[1])
+	}
+
+	return info
+}
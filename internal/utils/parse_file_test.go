@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"testing"
+
+	"go-winx-api/internal/models"
+)
+
+func TestParseFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want models.FileInfo
+	}{
+		// movies
+		{name: "Filme.2019.1080p.BluRay.x264.DUAL.mkv", want: models.FileInfo{Quality: "1080p"}},
+		{name: "Cidade.de.Deus.2002.720p.BRRip.XviD.AC3-NACIONAL.avi", want: models.FileInfo{Quality: "720p"}},
+		{name: "Central do Brasil (1998) [1080p] [WEB-DL] [H.264] [AAC 2.0].mp4", want: models.FileInfo{Quality: "1080p"}},
+		{name: "Movie.2021.2160p.UHD.BluRay.REMUX.HDR10.HEVC.DTS-HD.MA.7.1-GROUP.mkv", want: models.FileInfo{Quality: "2160p"}},
+		{name: "Movie.2021.4K.HDR.DV.WEB-DL.DDP5.1.Atmos.H.265.mkv", want: models.FileInfo{Quality: "2160p"}},
+		{name: "Movie_2020_UHD_x265_10bit.mkv", want: models.FileInfo{Quality: "2160p"}},
+		{name: "O.Auto.da.Compadecida.2000.480p.DVDRip.Dublado.avi", want: models.FileInfo{Quality: "480p"}},
+		{name: "Filme.Legendado.PT-BR.SRT.Embutida.mkv", want: models.FileInfo{}},
+		{name: "Filme.1920x1080.60fps.mkv", want: models.FileInfo{}},
+		{name: "Filme 2 - O Retorno.mp4", want: models.FileInfo{}},
+
+		// parts
+		{name: "Filme CD2.avi", want: models.FileInfo{Part: 2}},
+		{name: "Filme.1998.DVDRip.XviD.CD1.avi", want: models.FileInfo{Part: 1}},
+		{name: "Filme (Parte 2) 720p.mkv", want: models.FileInfo{Quality: "720p", Part: 2}},
+		{name: "Documentario.Part.3.of.4.1080p.HDTV.x264.mkv", want: models.FileInfo{Quality: "1080p", Part: 3}},
+		{name: "Filme.Disco.1.DVD9.iso", want: models.FileInfo{Part: 1}},
+		{name: "Filme.pt2.mp4", want: models.FileInfo{Part: 2}},
+
+		// episodes
+		{name: "Serie.S01E02.1080p.mkv", want: models.FileInfo{Quality: "1080p", Season: 1, Episode: 2}},
+		{name: "Serie.S03E10.720p.WEB-DL.DD5.1.H.264-GROUP.mkv", want: models.FileInfo{Quality: "720p", Season: 3, Episode: 10}},
+		{name: "serie.s1e5.480p.hdtv.x264.mp4", want: models.FileInfo{Quality: "480p", Season: 1, Episode: 5}},
+		{name: "Serie S02 E07 [1080p] [Dual Audio] [Legendado].mkv", want: models.FileInfo{Quality: "1080p", Season: 2, Episode: 7}},
+		{name: "Serie.S02-E07.mkv", want: models.FileInfo{Season: 2, Episode: 7}},
+		{name: "Serie.S01.E01.mkv", want: models.FileInfo{Season: 1, Episode: 1}},
+		{name: "Serie.S01E01E02.1080p.WEB.h264.mkv", want: models.FileInfo{Quality: "1080p", Season: 1, Episode: 1}},
+		{name: "Serie.S01E01-E02.mkv", want: models.FileInfo{Season: 1, Episode: 1}},
+		{name: "[Fansub] Anime - S01E105 [1080p][HEVC][10bit].mkv", want: models.FileInfo{Quality: "1080p", Season: 1, Episode: 105}},
+		{name: "Serie 2x05 HDTV XviD.avi", want: models.FileInfo{Season: 2, Episode: 5}},
+		{name: "Serie - 01x01 - Piloto.mkv", want: models.FileInfo{Season: 1, Episode: 1}},
+		{name: "Serie.1x102.720p.mkv", want: models.FileInfo{Quality: "720p", Season: 1, Episode: 102}},
+		{name: "Serie Temporada 2 Episodio 5 720p.mp4", want: models.FileInfo{Quality: "720p", Season: 2, Episode: 5}},
+		{name: "Novela - Episódio 45 - Dublado.mp4", want: models.FileInfo{Episode: 45}},
+		{name: "Serie.Season.4.Episode.12.1080p.AMZN.WEB-DL.mkv", want: models.FileInfo{Quality: "1080p", Season: 4, Episode: 12}},
+		{name: "Serie Temp 3 Ep 8.mkv", want: models.FileInfo{Season: 3, Episode: 8}},
+		{name: "Anime_EP_12_[720p].mkv", want: models.FileInfo{Quality: "720p", Episode: 12}},
+		{name: "Serie.S01.COMPLETE.1080p.mkv", want: models.FileInfo{Quality: "1080p"}},
+		{name: "Serie.S04E01.Parte.2.mkv", want: models.FileInfo{Season: 4, Episode: 1, Part: 2}},
+
+		// nothing to read
+		{name: "", want: models.FileInfo{}},
+		{name: "documento.pdf", want: models.FileInfo{}},
+		{name: "capa.jpg", want: models.FileInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseFileName(tt.name); got != tt.want {
+				t.Errorf("ParseFileName(%q) = %+v, want %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+
+	"go-winx-api/internal/models"
+)
+
+// Parser profiles, the caption layouts a channel can be read with.
+const (
+	// ProfileWinx reads the labelled fields of the captions of WinX.
+	ProfileWinx = "winx"
+	// ProfilePlain reads the first line as the title, hashtags as tags and
+	// the rest as the synopsis.
+	ProfilePlain = "plain"
+)
+
+var parserProfiles = map[string]func(string) models.MovieData{
+	ProfileWinx:  ParseMessageContent,
+	ProfilePlain: ParsePlainContent,
+}
+
+var titleYearRegex = regexp.MustCompile(`^(.*?)\s*[(\[#]\s*(\d{4})\s*[)\]]?\s*$`)
+
+// ValidParserProfile reports whether profile names a parser.
+func ValidParserProfile(profile string) bool {
+	_, ok := parserProfiles[profile]
+	return ok
+}
+
+// ParseMessageWithProfile parses a caption with the parser of profile,
+// falling back to ProfileWinx for unknown ones.
+func ParseMessageWithProfile(profile, content string) models.MovieData {
+	parse, ok := parserProfiles[profile]
+	if !ok {
+		parse = ParseMessageContent
+	}
+	return parse(content)
+}
+
+// ParsePlainContent parses a caption without labels, such as
+// "Title (2020)" followed by a description and a line of hashtags.
+func ParsePlainContent(content string) models.MovieData {
+	var data models.MovieData
+	var synopsis []string
+
+	for _, line := range splitAndTrim(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			data.Tags = append(data.Tags, splitAndTrim(line, "#")...)
+		case data.Title == "":
+			data.Title = line
+			if match := titleYearRegex.FindStringSubmatch(line); match != nil && match[1] != "" {
+				data.Title = match[1]
+				data.ReleaseDate = match[2]
+			}
+		default:
+			synopsis = append(synopsis, line)
+		}
+	}
+
+	data.Synopsis = strings.Join(synopsis, " ")
+	return data
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+
 	"go-winx-api/config"
 	"go-winx-api/internal/cache"
+	"go-winx-api/internal/index"
 	"go-winx-api/internal/server/http"
 	"go-winx-api/internal/services/telegram"
 	"go-winx-api/internal/utils"
@@ -25,7 +28,17 @@ func main() {
 		logger.Fatal("error while starting telegram client", zap.Error(err))
 	}
 
-	cache.InitCache(log)
+	if err := cache.InitCache(log); err != nil {
+		logger.Fatal("error while opening cache", zap.Error(err))
+	}
+	if err := cache.InitChunkCache(log); err != nil {
+		logger.Fatal("error while opening chunk cache", zap.Error(err))
+	}
+	telegram.InitAssembly(log)
+
+	if err := index.InitIndex(log); err != nil {
+		logger.Fatal("error while opening index", zap.Error(err))
+	}
 
 	workers, err := telegram.StartWorkers(log)
 	if err != nil {
@@ -34,6 +47,9 @@ func main() {
 	}
 
 	workers.AddDefaultClient(client, client.Self)
+	workers.StartHealthMonitor(context.Background())
+	telegram.StartBackfill(context.Background(), log)
+	telegram.StartSync(client, log)
 
 	logger.Info("server started", zap.Int("port", config.ValueOf.Port))
 	logger.Sugar().Infof("server is running at %s", config.ValueOf.Host)